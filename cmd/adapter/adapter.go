@@ -19,10 +19,13 @@ package main
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gravitational/logging-app/cmd/adapter/api"
 	"github.com/gravitational/logging-app/cmd/adapter/k8s"
+	"github.com/gravitational/logging-app/cmd/adapter/metrics"
+	"github.com/gravitational/logging-app/cmd/adapter/query"
 	log "github.com/gravitational/logrus"
 	"github.com/gravitational/trace"
 	lapi "github.com/logrange/logrange/api"
@@ -54,11 +57,21 @@ type (
 		k8sClient *k8s.Client
 		// Wait group to wait async jobs (started goroutines)
 		wg sync.WaitGroup
+		// Time (unix nanoseconds) of the last successful forwarders sync,
+		// it's the adapter start time till the first successful sync,
+		// must be accessed atomically
+		lastSyncNano int64
 
 		logger *log.Entry
 	}
 )
 
+const (
+	// Number of sync intervals without successful sync
+	// after which the adapter is not considered ready
+	syncMaxMissedIntervals = 5
+)
+
 // Runs new Adapter instance and blocks till err or context is cancelled
 func Run(ctx context.Context, cfg Config, cl lapi.Client) error {
 	ad := NewAdapter(cfg, cl)
@@ -86,12 +99,14 @@ func (ad *Adapter) Run(ctx context.Context) error {
 	// cancel in case if apiServer fails with error
 	// we need to cancel jobs in terms of this function
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	if err := ad.init(ctx); err != nil {
 		return trace.Wrap(err)
 	}
 
 	// async recurring jobs
+	atomic.StoreInt64(&ad.lastSyncNano, time.Now().UnixNano())
 	ad.startSync(ctx)
 	ad.startCronQueries(ctx)
 
@@ -106,6 +121,9 @@ func (ad *Adapter) Run(ctx context.Context) error {
 }
 
 func (ad *Adapter) init(ctx context.Context) error {
+	if err := query.SetKeyToField(ad.cfg.Gravity.QueryKeys); err != nil {
+		return trace.Wrap(err)
+	}
 	wTmpl, err := ad.cfg.Logrange.getForwarderTmpl()
 	if err != nil {
 		return trace.Wrap(err)
@@ -125,7 +143,38 @@ func (ad *Adapter) wait() error {
 // blocking
 func (ad *Adapter) runApiServer(ctx context.Context) error {
 	ad.logger.Info("Running API server on ", ad.cfg.Gravity.ApiListenAddr)
-	srv := api.NewServer(cfg.Gravity.ApiListenAddr, ad.lrClient, cfg.Logrange.Partition)
+	auth, err := api.NewAuthenticator(ad.cfg.Gravity.Auth)
+	if err != nil {
+		return trace.WrapWithMessage(err, "failed to create API authenticator")
+	}
+	// request client certificates if they are needed for authentication
+	var authClientCAFile string
+	if ad.cfg.Gravity.Auth != nil {
+		authClientCAFile = ad.cfg.Gravity.Auth.ClientCAFile
+	}
+	tlsCfg, err := api.NewTLSConfig(ad.cfg.Gravity.Tls, authClientCAFile)
+	if err != nil {
+		return trace.WrapWithMessage(err, "failed to create API TLS config")
+	}
+	// the TLS client CA certificates are verified during handshake only if they're given,
+	// so that the probes work without them, require them for the rest of the requests
+	auth, err = api.WithClientCertAuth(ad.cfg.Gravity.Tls, auth)
+	if err != nil {
+		return trace.WrapWithMessage(err, "failed to create API client certificate authenticator")
+	}
+	if auth == nil {
+		ad.logger.Warn("API authentication is disabled")
+	}
+	if tlsCfg == nil {
+		ad.logger.Warn("API TLS is disabled")
+	}
+	srv := api.NewServer(ad.cfg.Gravity.ApiListenAddr, ad.lrClient, ad.cfg.Logrange.Partition, auth, tlsCfg)
+	srv.AddReadyCheck("sync", ad.checkSync)
+	if ad.cfg.Gravity.ProbeListenAddr != "" {
+		ad.logger.Info("Serving API probes on ", ad.cfg.Gravity.ProbeListenAddr)
+		srv.SetProbeListenAddr(ad.cfg.Gravity.ProbeListenAddr)
+	}
+	srv.SetLokiTailOrigins(ad.cfg.Gravity.LokiTailOrigins)
 
 	ad.wg.Add(1)
 	go func() {
@@ -137,7 +186,7 @@ func (ad *Adapter) runApiServer(ctx context.Context) error {
 		_ = srv.Shutdown(sctx)
 	}()
 
-	err := srv.Serve(ctx)
+	err = srv.Serve(ctx)
 	ad.logger.Warn("API server stopped, err=", err)
 	return trace.Wrap(err)
 }
@@ -165,6 +214,7 @@ func (ad *Adapter) runCronQuery(ctx context.Context, cq cronQuery) {
 	for {
 		sleepDelay := time.Second * time.Duration(cq.PeriodSec)
 		res, err := ad.lrClient.Execute(ctx, lapi.ExecRequest{Query: cq.Query})
+		metrics.CronQueryRuns.WithLabelValues(cq.Query).Inc()
 		if err != nil {
 			ad.logger.Warn("Could not connect to the server to run ", cq)
 			sleepDelay = time.Second
@@ -172,6 +222,9 @@ func (ad *Adapter) runCronQuery(ctx context.Context, cq cronQuery) {
 		if res.Err != nil {
 			ad.logger.Error("Server returned error on executing ", cq.Query, " the err=", res.Err)
 		}
+		if err != nil || res.Err != nil {
+			metrics.CronQueryErrors.WithLabelValues(cq.Query).Inc()
+		}
 
 		select {
 		case <-ctx.Done():
@@ -192,8 +245,25 @@ func (ad *Adapter) startSync(ctx context.Context) {
 	go func() {
 		defer ad.wg.Done()
 		for utils.Wait(ctx, ticker) {
-			ad.k8sClient.SyncForwarders(ctx)
+			if err := ad.k8sClient.SyncForwarders(ctx); err != nil {
+				metrics.SyncRuns.WithLabelValues(metrics.SyncFailure).Inc()
+				continue
+			}
+			metrics.SyncRuns.WithLabelValues(metrics.SyncSuccess).Inc()
+			metrics.SyncLastSuccess.SetToCurrentTime()
+			atomic.StoreInt64(&ad.lastSyncNano, time.Now().UnixNano())
 		}
 		ad.logger.Warn("Sync stopped.")
 	}()
 }
+
+// Readiness check, returns error if forwarders (K8s config maps)
+// have not been synced successfully for too long
+func (ad *Adapter) checkSync(ctx context.Context) error {
+	maxAge := time.Duration(syncMaxMissedIntervals*ad.cfg.SyncIntervalSec) * time.Second
+	age := time.Since(time.Unix(0, atomic.LoadInt64(&ad.lastSyncNano)))
+	if age > maxAge {
+		return trace.Errorf("last successful sync was %v ago, max allowed %v", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
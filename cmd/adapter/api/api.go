@@ -17,19 +17,19 @@ limitations under the License.
 package api
 
 import (
-	"archive/tar"
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/LK4D4/joincontext"
+	"github.com/gravitational/logging-app/cmd/adapter/metrics"
 	"github.com/gravitational/logging-app/cmd/adapter/query"
 	log "github.com/gravitational/logrus"
 	"github.com/gravitational/trace"
@@ -45,10 +45,20 @@ type (
 	Server struct {
 		// Http server
 		server *http.Server
+		// Plaintext http server of the probes, nil if the probes are served by the server only
+		probeServer *http.Server
 		// Logrange client query
 		lrClient api.Client
 		// Logrange query partition
 		lrPartition string
+		// Requests authenticator, nil if authentication is disabled
+		auth Authenticator
+		// Additional checks run on readiness requests
+		readyChecks []healthCheck
+		// Recently seen fields and their values
+		fields fieldsCache
+		// Origins allowed to open Loki tail WebSocket besides the API host
+		lokiTailOrigins []string
 
 		logger *log.Entry
 	}
@@ -63,21 +73,21 @@ type (
 		status int
 	}
 
+	// Http response writer which writes the given status code
+	// instead of any other one (trace.WriteError has no 401 code support)
+	responseWriterWithCode struct {
+		http.ResponseWriter
+		code int
+	}
+
 	// Represents gravitational log entry
 	grLogEntry struct {
 		Type    string            `json:"type"`
 		Payload string            `json:"payload"`
 		Tags    map[string]string `json:"tags"`
 		Fields  map[string]string `json:"fields"`
-	}
-
-	// Compressed tarball writer
-	tarGzEntryWriter struct {
-		entryNum  int
-		entryPrfx string
-
-		gzWriter  *gzip.Writer
-		tarWriter *tar.Writer
+		// Either "match" or "context", set only if context lines are requested
+		Kind string `json:"kind,omitempty"`
 	}
 )
 
@@ -88,6 +98,13 @@ const (
 	// Log tail default offset
 	defaultTailLinesOffset = -1000
 
+	// Log stream maximum number of lines per single Logrange query
+	streamBatchLinesLimit = 1000
+
+	// Log stream timeout (in seconds) to wait for new data,
+	// Logrange doesn't allow it to exceed 60 seconds
+	streamWaitTimeoutSec = 30
+
 	// Log download maximum number of lines
 	downloadLinesMax = 500000000
 
@@ -100,35 +117,96 @@ const (
 
 // NewServer creates api server for the given params,
 // it has Serve() and Shutdown() lifecycle methods
-// it's caller's responsibility to call them appropriately
-func NewServer(listenAddr string, lrClient api.Client, lrPartition string) *Server {
+// it's caller's responsibility to call them appropriately.
+// If auth is nil, the requests are not authenticated.
+// If tlsCfg is nil, the server listens for plaintext HTTP.
+func NewServer(listenAddr string, lrClient api.Client, lrPartition string,
+	auth Authenticator, tlsCfg *tls.Config) *Server {
 	return &Server{
-		server:      &http.Server{Addr: listenAddr},
-		lrClient:    lrClient,
+		server:      &http.Server{Addr: listenAddr, TLSConfig: tlsCfg},
+		lrClient:    &lrClientWithMetrics{Client: lrClient},
 		lrPartition: lrPartition,
+		auth:        auth,
 		logger:      log.WithField(trace.Component, "logging-app.api"),
 	}
 }
 
-// Starts serving requests on the configured port, blocking, returns error
-// if underlying http.Server.Listen() returns err != http.ErrServerClosed
+// Starts serving requests on the configured port (over TLS if it's configured)
+// and the probes on the probe port (if it's set, see SetProbeListenAddr()),
+// blocking, returns error if underlying http.Server.Listen() returns err != http.ErrServerClosed
 func (s *Server) Serve(ctx context.Context) error {
-	router := httprouter.New()
-	router.GET("/v1/log", s.makeHandlerWithCtx(ctx, s.logHandler))
-	router.GET("/v1/download", s.makeHandlerWithCtx(ctx, s.downloadHandler))
+	s.server.Handler = s.newRouter(ctx)
+	if s.probeServer == nil {
+		return listenAndServe(s.server)
+	}
 
-	s.server.Handler = router
-	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+	s.probeServer.Handler = s.newProbeRouter(ctx)
+	errs := make(chan error, 2)
+	go func() { errs <- listenAndServe(s.probeServer) }()
+	go func() { errs <- listenAndServe(s.server) }()
+	// if either of the servers fails, the other one is stopped too
+	err := <-errs
+	if err != nil {
+		_ = s.server.Close()
+		_ = s.probeServer.Close()
+	}
+	if err2 := <-errs; err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Listens and serves the given http server (over TLS if it's configured),
+// returns nil if the server is shut down
+func listenAndServe(srv *http.Server) error {
+	var err error
+	if srv.TLSConfig != nil {
+		// the certificate is provided by TLSConfig.GetCertificate
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		return trace.Wrap(err)
 	}
 
 	return nil
 }
 
+// Returns the router of all the api routes, the handlers are run with the given context
+func (s *Server) newRouter(ctx context.Context) *httprouter.Router {
+	router := httprouter.New()
+	s.handle(ctx, router, "/v1/log", s.logHandler)
+	s.handle(ctx, router, "/v1/stream", s.streamHandler)
+	s.handle(ctx, router, "/v2/log", s.logV2Handler)
+	s.handle(ctx, router, "/v1/download", s.downloadHandler)
+	s.handle(ctx, router, "/v1/stats", s.statsHandler)
+	s.handle(ctx, router, "/v1/fields", s.fieldsHandler)
+	s.handle(ctx, router, "/v1/fields/:name/values", s.fieldValuesHandler)
+	s.handle(ctx, router, "/v1/explain", s.explainHandler)
+	s.handle(ctx, router, "/loki/api/v1/query_range", s.lokiQueryRangeHandler)
+	s.handle(ctx, router, "/loki/api/v1/labels", s.lokiLabelsHandler)
+	s.handle(ctx, router, "/loki/api/v1/label/:name/values", s.lokiLabelValuesHandler)
+	s.handle(ctx, router, "/loki/api/v1/tail", s.lokiTailHandler)
+	s.addProbeRoutes(ctx, router)
+
+	// the metrics requests are authenticated, but not observed
+	metricsHandler := metrics.Handler()
+	router.GET("/metrics", s.withAuth(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		metricsHandler.ServeHTTP(w, r)
+	}))
+	return router
+}
+
 // Shutdown gracefully shuts down the server.
 // It blocks until the server has shut down or context has expired.
 func (s *Server) Shutdown(ctx context.Context) error {
 	err := s.server.Shutdown(ctx)
+	if s.probeServer != nil {
+		if perr := s.probeServer.Shutdown(ctx); err == nil {
+			err = perr
+		}
+	}
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -138,35 +216,94 @@ func (s *Server) Shutdown(ctx context.Context) error {
 // "/v1/log" api handler, returns logs from tail for the given params:
 //
 // - 'query':
-//      allowed query terms: "pod", "container", "file", "or", "and"
-//      example: query="pod:p1 and container:c1 and file:f1 or file:f2"
+//      allowed query terms: "pod", "container", "file", "since", "after", "before", "or", "and", "not"
+//      example: query="pod:p1 and container:c1 and file:f1 or file:f2 and since:30m"
 // - 'limit':
 //      allowed values: int >= 0
 //      example: limit=100
+// - 'since', 'until':
+//      allowed values: RFC3339 timestamp or duration relative to now
+//      example: since=15m&until=2019-01-01T10:00:00Z
+// - 'cursor':
+//      opaque value of the "X-Log-Cursor" header returned with the previous page,
+//      the query params must be the same as for the previous page
+// - 'direction':
+//      allowed values: "backward" (older entries, default) or "forward" (newer entries),
+//      used along with 'cursor'
+//      example: cursor=eyJwb3MiOi...&direction=backward
+// - 'before', 'after':
+//      number of context lines (of the same pod and container) to return
+//      before/after each matched entry, the entries are marked as "match" or "context",
+//      the context lines of each match are queried separately (up to 2 queries per match),
+//      so when context is requested the 'limit' is capped at 20
+//      allowed values: int in [0, 100]
+//      example: query="error"&before=5&after=5
+// - 'strict':
+//      allowed values: bool, if true the invalid 'query' results in 400 error
+//      (with the error position and expected tokens) instead of literal text search
+//      example: strict=true
+// - 'follow':
+//      allowed values: bool, if true the request is served as "/v1/stream"
+//      example: follow=true
+//
+// The response format is chosen by 'Accept' header: "application/x-ndjson",
+// "text/plain" or "text/csv", otherwise it's JSON array of JSON-encoded
+// Gravity log entries (what Gravity expects).
 //
 // In case of error it returns the error (no response write happens) so it's up to
 // caller to handle it properly, e.g. return appropriate HTTP code.
 //
 func (s *Server) logHandler(ctx context.Context, rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	if follow, _ := strconv.ParseBool(rq.URL.Query().Get("follow")); follow {
+		return s.streamHandler(ctx, rw, rq, p)
+	}
+	return s.serveLog(ctx, rw, rq, getLogFormat(rq))
+}
+
+// Serves log request (see "/v1/log" params), writes the found
+// entries (along with their context, if requested) in the given format
+func (s *Server) serveLog(ctx context.Context, rw http.ResponseWriter, rq *http.Request, format *logFormat) error {
 	var err error
 
 	// get query params
 	queryParam := strings.TrimSpace(rq.URL.Query().Get("query"))
-	limitParam := strings.TrimSpace(rq.URL.Query().Get("limit"))
+	if err = checkStrictQuery(rq, queryParam); err != nil {
+		return trace.Wrap(err)
+	}
+	limit := s.getLimitParam(rq, "log()")
+	tr, err := getTimeRangeParams(rq, time.Now())
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	before, err := getContextParam(rq, "before")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	after, err := getContextParam(rq, "after")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if (before > 0 || after > 0) && limit > contextMatchesMax {
+		limit = contextMatchesMax
+	}
 
-	// validate and set limit
-	limit := defaultTailLinesLimit
-	if limitParam != "" {
-		limit, err = strconv.Atoi(limitParam)
-		if err != nil || limit < 0 {
-			s.logger.Warn("log(): Bad limit=", limitParam, ", using default; err=", err)
-			limit = defaultTailLinesLimit
+	// get page position, it's either tail or relative to the given cursor
+	pos, offset := "tail", defaultTailLinesOffset
+	if cursorParam := strings.TrimSpace(rq.URL.Query().Get("cursor")); cursorParam != "" {
+		cur, err := decodeLogCursor(cursorParam)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		pos = cur.Pos
+		offset, err = cur.offset(strings.TrimSpace(rq.URL.Query().Get("direction")), limit)
+		if err != nil {
+			return trace.Wrap(err)
 		}
 	}
 
 	// build Logrange query
-	qr := s.buildQueryRequest(queryParam, "tail", limit, defaultTailLinesOffset)
-	s.logger.Info("log(): Query=", qr.Query)
+	qr := s.buildQueryRequest(queryParam, getQueryOptions(rq), tr, pos, limit, offset)
+	s.logger.Info("log(): Query=", qr.Query, ", Pos=", qr.Pos, ", Offset=", qr.Offset)
 
 	// join contexts to handle both server interruption (SIGINT) and transport err
 	jctx, cancel := joincontext.Join(ctx, rq.Context())
@@ -178,28 +315,116 @@ func (s *Server) logHandler(ctx context.Context, rw http.ResponseWriter, rq *htt
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	if res.Err != nil {
+		return trace.Wrap(res.Err)
+	}
 
-	// transform to Gravity format
-	var logEntries []string
-	logEntries, err = toGravityLogEntries(res.Events)
-	if err != nil {
-		return trace.Wrap(err)
+	// add context lines around the matches, if requested
+	evs, marks := res.Events, []eventMark(nil)
+	if before > 0 || after > 0 {
+		ce, err := s.withContext(jctx, res.Events, before, after)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		evs, marks = ce.evs, ce.marks
 	}
 
-	// marshal and write response
-	var logEntriesBytes []byte
-	logEntriesBytes, err = json.Marshal(logEntries)
-	if err != nil {
+	// transform to the requested format
+	var buf bytes.Buffer
+	if err = format.write(evs, marks, &buf); err != nil {
 		return trace.Wrap(err)
 	}
 
-	_, err = rw.Write(logEntriesBytes)
+	rw.Header().Set("Content-Type", format.contentType)
+	if res.NextQueryRequest.Pos != "" {
+		cur := &logCursor{Pos: res.NextQueryRequest.Pos, Count: len(res.Events)}
+		rw.Header().Set(logCursorHeader, cur.encode())
+	}
+	_, err = rw.Write(buf.Bytes())
 	return trace.Wrap(err)
 }
 
-// "/v1/download" api handler, returns compressed tarball stream of logs
+// "/v1/stream" api handler, returns logs from tail for the given params
+// and then keeps the connection open, pushing new log entries as they arrive.
+// The response is chunked NDJSON (newline delimited JSON), each line is a
+// single log entry of the same format as the "/v1/log" ones.
 //
-// No query params are supported.
+// Supported params are the same as for "/v1/log":
+//
+// - 'query': see "/v1/log"
+// - 'limit':
+//      number of the already stored entries to write before following new ones
+//      allowed values: int >= 0
+//      example: limit=0
+//
+// The stream ends when either the client goes away or the server is being stopped.
+// In case of error it returns the error, it's up to caller to handle it properly,
+// e.g. return appropriate HTTP code if nothing has been written yet.
+//
+func (s *Server) streamHandler(ctx context.Context, rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	queryParam := strings.TrimSpace(rq.URL.Query().Get("query"))
+	limit := s.getLimitParam(rq, "stream()")
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		return trace.NotImplemented("streaming is not supported by the response writer")
+	}
+
+	// build Logrange query, it starts 'limit' entries before the tail
+	// and then waits for new entries at most streamWaitTimeoutSec per query
+	qr := s.buildQueryRequest(queryParam, getQueryOptions(rq), query.TimeRange{}, "tail", streamBatchLinesLimit, -limit)
+	qr.WaitTimeout = streamWaitTimeoutSec
+	s.logger.Info("stream(): Query=", qr.Query)
+
+	// join contexts to handle both server interruption (SIGINT) and transport err
+	jctx, cancel := joincontext.Join(ctx, rq.Context())
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// execute Logrange query in stream mode, every next query
+	// continues from where the previous one stopped (NextQueryRequest)
+	buf := bytes.Buffer{}
+	err := api.Select(jctx, s.lrClient, qr, true,
+		func(res *api.QueryResult) {
+			buf.Reset()
+			errW := writeGravityLogEntries(res.Events, nil, &buf)
+			if errW == nil {
+				_, errW = rw.Write(buf.Bytes())
+			}
+			if errW != nil {
+				s.logger.Error("stream(): Response write err=", errW)
+				s.connHangUp() // the handler aborts here, see connHangUp() comments
+			}
+			flusher.Flush()
+		})
+
+	// the stream is stopped by either client or server, it's not an error
+	if jctx.Err() != nil {
+		s.logger.Info("stream(): Stopped, ctx err=", jctx.Err())
+		return nil
+	}
+	return trace.Wrap(err)
+}
+
+// "/v1/download" api handler, returns compressed archive stream of logs
+// for the given params:
+//
+// - 'query': see "/v1/log"
+// - 'since', 'until': see "/v1/log"
+// - 'format':
+//      allowed values: "tar.gz" (default), "zip" or "ndjson.gz",
+//      if not given the format is chosen by 'Accept' header
+//      example: format=zip
+// - 'layout':
+//      allowed values: "flat" (default) or "source", the former writes all
+//      the entries into "messages", "messages.0", ... files, the latter groups
+//      the entries by their source into "<namespace>/<pod>/<container>.log" files
+//      example: layout=source
+// - 'strict': see "/v1/log"
 //
 // In case of error it returns the error so it's up to caller to handle it properly,
 // e.g. return appropriate HTTP code.
@@ -215,31 +440,47 @@ func (s *Server) logHandler(ctx context.Context, rw http.ResponseWriter, rq *htt
 //
 func (s *Server) downloadHandler(ctx context.Context,
 	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
-	rw.Header().Set("Content-Disposition", "attachment; filename=logs.tar.gz")
+	queryParam := strings.TrimSpace(rq.URL.Query().Get("query"))
+	if err := checkStrictQuery(rq, queryParam); err != nil {
+		return trace.Wrap(err)
+	}
+	tr, err := getTimeRangeParams(rq, time.Now())
+	if err != nil {
+		return trace.Wrap(err)
+	}
 
-	// prepare stream writer
-	tgEntryWriter := newTarGzEntryWriter(rw, downloadFilenamePrfx)
-	defer tgEntryWriter.close()
+	format, err := getDownloadFormat(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	rw.Header().Set("Content-Type", format.contentType)
+	rw.Header().Set("Content-Disposition", "attachment; filename="+format.fileName)
+
+	// prepare stream writer, it's finalized only if the whole result is written,
+	// so that the failed download is either the error response or the truncated archive
+	entryWriter := format.newWriter(rw, downloadFilenamePrfx)
+
+	layout, err := newEntryLayout(strings.TrimSpace(rq.URL.Query().Get("layout")), entryWriter)
+	if err != nil {
+		return trace.Wrap(err)
+	}
 
 	// build Logrange query
-	qr := s.buildQueryRequest("", "head", downloadLinesMax, 0)
+	qr := s.buildQueryRequest(queryParam, getQueryOptions(rq), tr, "head", downloadLinesMax, 0)
 	s.logger.Info("download(): Query=", qr.Query)
 
 	// join contexts to handle both server interruption (e.g. SIGINT) and transport err (e.g. broken pipe)
 	jctx, cancel := joincontext.Join(ctx, rq.Context())
 	defer cancel()
 
-	// execute Logrange query and write tar.gz stream
-	buf := bytes.Buffer{}
-	err := api.Select(jctx, s.lrClient, qr, false,
+	// execute Logrange query and write archive stream
+	err = api.Select(jctx, s.lrClient, qr, false,
 		func(res *api.QueryResult) {
-			writeEvents(res.Events, &buf)
-			if buf.Len() > downloadBytesPerFileLimit {
-				errW := tgEntryWriter.write(buf.Bytes())
-				if errW != nil {
-					s.logger.Error("download(): Response write err=", errW)
-					s.connHangUp() // the handler aborts here, see connHangUp() comments
-				}
+			errW := layout.add(res.Events)
+			if errW != nil {
+				s.logger.Error("download(): Response write err=", errW)
+				s.connHangUp() // the handler aborts here, see connHangUp() comments
 			}
 		})
 
@@ -249,13 +490,12 @@ func (s *Server) downloadHandler(ctx context.Context,
 	}
 
 	// still some data, write it now
-	if buf.Len() > 0 {
-		errW := tgEntryWriter.write(buf.Bytes())
-		if errW != nil {
-			s.logger.Error("download(): Response write err=", errW)
-			s.connHangUp() // the handler aborts here, see connHangUp() comments
-		}
+	errW := layout.flush()
+	if errW != nil {
+		s.logger.Error("download(): Response write err=", errW)
+		s.connHangUp() // the handler aborts here, see connHangUp() comments
 	}
+	entryWriter.close()
 
 	return nil
 }
@@ -274,13 +514,93 @@ func (s *Server) connHangUp() {
 	panic(http.ErrAbortHandler)
 }
 
-func (s *Server) buildQueryRequest(q string, p string, limit int, offset int) *api.QueryRequest {
+// Returns 'limit' request param if it's valid, otherwise the default one
+func (s *Server) getLimitParam(rq *http.Request, caller string) int {
+	limitParam := strings.TrimSpace(rq.URL.Query().Get("limit"))
+	if limitParam == "" {
+		return defaultTailLinesLimit
+	}
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil || limit < 0 {
+		s.logger.Warn(caller, ": Bad limit=", limitParam, ", using default; err=", err)
+		return defaultTailLinesLimit
+	}
+	return limit
+}
+
+// Returns 'since' and 'until' request params as time range,
+// the relative values are calculated against the given time
+func getTimeRangeParams(rq *http.Request, now time.Time) (query.TimeRange, error) {
+	var tr query.TimeRange
+	var err error
+	if tr.Since, err = parseTimeParam(rq.URL.Query().Get("since"), now); err != nil {
+		return tr, trace.BadParameter("invalid since: %v", err)
+	}
+	if tr.Until, err = parseTimeParam(rq.URL.Query().Get("until"), now); err != nil {
+		return tr, trace.BadParameter("invalid until: %v", err)
+	}
+	if !tr.Since.IsZero() && !tr.Until.IsZero() && tr.Since.After(tr.Until) {
+		return tr, trace.BadParameter("invalid since=%v: must not be after until=%v", tr.Since, tr.Until)
+	}
+	return tr, nil
+}
+
+// Returns the query translation options given by the request params
+func getQueryOptions(rq *http.Request) query.Options {
+	caseSensitive, _ := strconv.ParseBool(rq.URL.Query().Get("caseSensitive"))
+	return query.Options{CaseSensitive: caseSensitive}
+}
+
+// Parses time param which is either RFC3339 timestamp or duration
+// (e.g. "15m", "1h30m") relative to the given time, empty value results in zero time.
+// The time must be representable as Unix nanoseconds (see query.ParseTime()),
+// so the explicitly given zero time is an error rather than an unset param.
+func parseTimeParam(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := query.ParseTime(v, now)
+	if err != nil {
+		return time.Time{}, trace.BadParameter(err.Error())
+	}
+	return t, nil
+}
+
+func (s *Server) buildQueryRequest(q string, opts query.Options, tr query.TimeRange, p string, limit int, offset int) *api.QueryRequest {
 	return &api.QueryRequest{
-		Query: query.BuildLqlQuery(q, s.lrPartition, limit, offset),
+		Query: query.BuildLqlQuery(q, opts, s.lrPartition, tr, limit, offset),
 		Pos:   p, Offset: offset, Limit: limit,
 	}
 }
 
+// Registers GET handler for the given route
+func (s *Server) handle(ctx context.Context, router *httprouter.Router, route string, handler handlerWithCtx) {
+	router.GET(route, withMetrics(route, s.withAuth(s.makeHandlerWithCtx(ctx, handler))))
+}
+
+// Registers GET handler for the given route, the requests are not authenticated,
+// it's intended for the probes, which must be accessible by kubelet
+func (s *Server) handlePublic(ctx context.Context, router *httprouter.Router, route string, handler handlerWithCtx) {
+	router.GET(route, withMetrics(route, s.makeHandlerWithCtx(ctx, handler)))
+}
+
+// Wrapper for http handler, it authenticates the request before calling
+// the actual handler (if authentication is enabled)
+func (s *Server) withAuth(handle httprouter.Handle) httprouter.Handle {
+	if s.auth == nil {
+		return handle
+	}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if err := s.auth.Authenticate(r); err != nil {
+			s.logger.Warn("Request=", r.Method, " ", r.URL, " from ", r.RemoteAddr, " denied; err=", err)
+			writeError(w, err)
+			return
+		}
+		handle(w, r, p)
+	}
+}
+
 // Wrapper for http handler, besides calling the actual handler it
 // tries to handle returned errors (if any). In particular,
 // it logs the request, error and writes http error
@@ -293,11 +613,29 @@ func (s *Server) makeHandlerWithCtx(ctx context.Context, handler handlerWithCtx)
 		}
 		s.logger.Error("Request=", r, "; err=", err)
 		if rw.status == 0 { // write err/status if there were no writes
-			trace.WriteError(rw, err)
+			writeError(rw, err)
 		}
 	}
 }
 
+// Writes the given error as HTTP response, authentication
+// failures are written with 401 HTTP code and challenge header,
+// query parse errors are written with the error details
+func writeError(rw http.ResponseWriter, err error) {
+	if writeQueryParseError(rw, err) {
+		return
+	}
+	if IsUnauthenticated(err) {
+		rw.Header().Set("WWW-Authenticate", authChallenge)
+		rw = &responseWriterWithCode{ResponseWriter: rw, code: http.StatusUnauthorized}
+	}
+	trace.WriteError(rw, err)
+}
+
+func (w *responseWriterWithCode) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
 func (w *responseWriterWithStatus) WriteHeader(status int) {
 	w.status = status
 	w.ResponseWriter.WriteHeader(status)
@@ -308,49 +646,46 @@ func (w *responseWriterWithStatus) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
-func newTarGzEntryWriter(w io.Writer, entryPrfx string) *tarGzEntryWriter {
-	tgWriter := new(tarGzEntryWriter)
-	tgWriter.entryPrfx = entryPrfx
-	tgWriter.gzWriter = gzip.NewWriter(w)
-	tgWriter.tarWriter = tar.NewWriter(tgWriter.gzWriter)
-	return tgWriter
-}
-
-func (w *tarGzEntryWriter) write(b []byte) error {
-	err := w.tarWriter.WriteHeader(w.nextEntryHeader(len(b)))
-	if err == nil {
-		_, err = w.tarWriter.Write(b)
+// Flush implements http.Flusher, so that streaming handlers
+// are able to push the written data to client immediately
+func (w *responseWriterWithStatus) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
-	return trace.Wrap(err)
 }
 
-func (w *tarGzEntryWriter) nextEntryHeader(size int) *tar.Header {
-	name := w.entryPrfx
-	if w.entryNum > 0 {
-		name = fmt.Sprintf("%v.%v", w.entryPrfx, w.entryNum-1)
-	}
-	w.entryNum++
-	return &tar.Header{
-		Name:     name,
-		ModTime:  time.Now(),
-		Mode:     0777,
-		Typeflag: tar.TypeReg,
-		Size:     int64(size),
+// Hijack implements http.Hijacker, so that the connection
+// can be taken over, e.g. by WebSocket handler
+func (w *responseWriterWithStatus) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, trace.NotImplemented("hijacking is not supported by the response writer")
 	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
 }
 
-func (w *tarGzEntryWriter) close() {
-	if w.entryNum > 0 {
-		_ = w.tarWriter.Close()
-		_ = w.gzWriter.Close()
+// Writes the given value as JSON response
+func writeJson(rw http.ResponseWriter, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return trace.Wrap(err)
 	}
+	rw.Header().Set("Content-Type", "application/json")
+	_, err = rw.Write(b)
+	return trace.Wrap(err)
 }
 
-func toGravityLogEntries(evs []*api.LogEvent) ([]string, error) {
+// Transforms the given events to JSON-encoded Gravity log entries,
+// marks are optional, if given they must be aligned with the events
+func toGravityLogEntries(evs []*api.LogEvent, marks []eventMark) ([]string, error) {
 	entries := make([]string, 0, len(evs))
 	logEntry := &grLogEntry{Type: "data"}
-	for _, e := range evs {
+	for i, e := range evs {
 		logEntry.Payload = e.Message
+		if marks != nil {
+			logEntry.Kind = marks[i].String()
+		}
 
 		logEntry.Tags = parseCSVIntoMap(e.Tags)
 
@@ -365,6 +700,20 @@ func toGravityLogEntries(evs []*api.LogEvent) ([]string, error) {
 	return entries, nil
 }
 
+// Writes the given events as NDJSON (one Gravity log entry per line),
+// marks are optional, if given they must be aligned with the events
+func writeGravityLogEntries(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	entries, err := toGravityLogEntries(evs, marks)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, e := range entries {
+		buf.WriteString(e)
+		buf.WriteByte('\n')
+	}
+	return nil
+}
+
 // parseCSVIntoMap takes in a string with a CSV (Comma Separated Value) string
 // with each element in the form `key=value` and translates it into a "dictionary" map
 func parseCSVIntoMap(csv string) (results map[string]string) {
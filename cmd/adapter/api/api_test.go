@@ -20,10 +20,15 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	log "github.com/gravitational/logrus"
 	"github.com/logrange/logrange/api"
 )
 
@@ -61,16 +66,16 @@ func TestServer_buildQueryRequest(t *testing.T) {
 			args: args{q: "file:f1 or pod:p1", pos: "tail", limit: 123, offset: -10},
 			want: &api.QueryRequest{
 				Limit: 123, Offset: -10, Pos: "tail",
-				Query: "SELECT FROM partition WHERE (fields:cid=\"f1\" OR fields:pod=\"p1\") OR " +
-					"fields:file CONTAINS \"f1\" OFFSET -10 LIMIT 123",
+				Query: "SELECT FROM partition WHERE ((fields:cid=\"f1\" OR fields:file CONTAINS \"f1\") OR " +
+					"fields:pod=\"p1\") OFFSET -10 LIMIT 123",
 			},
 		},
 		{
 			name: "build head request ok", args: args{q: "file:f1 or pod:p1", pos: "head", limit: 123, offset: 10},
 			want: &api.QueryRequest{
 				Limit: 123, Offset: 10, Pos: "head",
-				Query: "SELECT FROM partition WHERE (fields:cid=\"f1\" OR fields:pod=\"p1\") OR " +
-					"fields:file CONTAINS \"f1\" OFFSET 10 LIMIT 123",
+				Query: "SELECT FROM partition WHERE ((fields:cid=\"f1\" OR fields:file CONTAINS \"f1\") OR " +
+					"fields:pod=\"p1\") OFFSET 10 LIMIT 123",
 			},
 		},
 	}
@@ -79,7 +84,7 @@ func TestServer_buildQueryRequest(t *testing.T) {
 			s := &Server{
 				lrPartition: "partition",
 			}
-			if got := s.buildQueryRequest(tt.args.q,
+			if got := s.buildQueryRequest(tt.args.q, query.Options{}, query.TimeRange{},
 				tt.args.pos, tt.args.limit, tt.args.offset); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Server.buildQueryRequest() = %v, want %v", got, tt.want)
 			}
@@ -87,49 +92,272 @@ func TestServer_buildQueryRequest(t *testing.T) {
 	}
 }
 
-func Test_tarGzEntryWriter_nextEntryHeader(t *testing.T) {
-	want := &tar.Header{Name: "prefix.0",
-		ModTime: time.Now(), Mode: 0777, Typeflag: tar.TypeReg, Size: 123}
+func Test_getQueryOptions(t *testing.T) {
+	tests := []struct {
+		url  string
+		want query.Options
+	}{
+		{url: "/v1/log?query=error", want: query.Options{}},
+		{url: "/v1/log?query=Error&caseSensitive=true", want: query.Options{CaseSensitive: true}},
+		{url: "/v1/log?query=Error&caseSensitive=1", want: query.Options{CaseSensitive: true}},
+		{url: "/v1/log?query=Error&caseSensitive=no", want: query.Options{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := getQueryOptions(httptest.NewRequest("GET", tt.url, nil)); got != tt.want {
+				t.Errorf("getQueryOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
 
-	w := &tarGzEntryWriter{
-		entryNum:  1,
-		entryPrfx: "prefix",
+func Test_getTimeRangeParams(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		params  string
+		want    query.TimeRange
+		wantErr bool
+	}{
+		{
+			name:   "no params ok",
+			params: "",
+			want:   query.TimeRange{},
+		},
+		{
+			name:   "relative since ok",
+			params: "since=15m",
+			want:   query.TimeRange{Since: now.Add(-15 * time.Minute)},
+		},
+		{
+			name:   "absolute since and until ok",
+			params: "since=2019-01-01T08:00:00Z&until=2019-01-01T09:00:00.5Z",
+			want: query.TimeRange{
+				Since: time.Date(2019, time.January, 1, 8, 0, 0, 0, time.UTC),
+				Until: time.Date(2019, time.January, 1, 9, 0, 0, 500000000, time.UTC),
+			},
+		},
+		{
+			name:    "bad since err",
+			params:  "since=yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "zero since err",
+			params:  "since=0001-01-01T00:00:00Z",
+			wantErr: true,
+		},
+		{
+			name:    "since before unix nanos range err",
+			params:  "since=1600-01-01T00:00:00Z",
+			wantErr: true,
+		},
+		{
+			name:    "until after unix nanos range err",
+			params:  "until=2300-01-01T00:00:00Z",
+			wantErr: true,
+		},
+		{
+			name:    "negative until err",
+			params:  "until=-15m",
+			wantErr: true,
+		},
+		{
+			name:    "since after until err",
+			params:  "since=1h&until=2h",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/v1/log?"+tt.params, nil)
+			got, err := getTimeRangeParams(rq, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getTimeRangeParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getTimeRangeParams() = %v, want %v", got, tt.want)
+			}
+		})
 	}
+}
 
-	got := w.nextEntryHeader(123)
-	gtt := got.ModTime.Add(time.Minute)
+func TestServer_logHandler(t *testing.T) {
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{
+				Events:           []*api.LogEvent{{Message: "m1"}, {Message: "m2"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos1"},
+			},
+			{
+				Events:           []*api.LogEvent{{Message: "m0"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos0"},
+			},
+		},
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "log")}
 
-	want.ModTime = got.ModTime
-	if gtt.Before(want.ModTime) {
-		t.Errorf("tarGzEntryWriter.nextEntryHeader() = %v, want %v",
-			got.ModTime, want.ModTime)
+	// first page
+	rw := httptest.NewRecorder()
+	if err := s.logHandler(context.Background(), rw,
+		httptest.NewRequest("GET", "/v1/log?limit=2", nil), nil); err != nil {
+		t.Fatalf("Server.logHandler() error = %v", err)
 	}
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("tarGzEntryWriter.nextEntryHeader() = %v, want %v",
-			got, want)
+	cursor := rw.Header().Get(logCursorHeader)
+	if want := (&logCursor{Pos: "pos1", Count: 2}).encode(); cursor != want {
+		t.Fatalf("Server.logHandler() cursor = %v, want %v", cursor, want)
+	}
+
+	// previous page
+	rw = httptest.NewRecorder()
+	if err := s.logHandler(context.Background(), rw,
+		httptest.NewRequest("GET", "/v1/log?limit=2&direction=backward&cursor="+cursor, nil), nil); err != nil {
+		t.Fatalf("Server.logHandler() error = %v", err)
+	}
+	if got := cli.reqs[1]; got.Pos != "pos1" || got.Offset != -4 || got.Limit != 2 {
+		t.Errorf("Server.logHandler() query = %v, want Pos=pos1, Offset=-4, Limit=2", &got)
+	}
+	want := "[\"{\\\"type\\\":\\\"data\\\",\\\"payload\\\":\\\"m0\\\",\\\"tags\\\":{\\\"\\\":\\\"\\\"},\\\"fields\\\":{\\\"\\\":\\\"\\\"}}\"]"
+	if got := rw.Body.String(); got != want {
+		t.Errorf("Server.logHandler() = %v, want %v", got, want)
 	}
 }
 
-func Test_tarGzEntryWriter_write(t *testing.T) {
-	want := []byte("test")
+func TestServer_streamHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	//write
-	wbuf := bytes.Buffer{}
-	gw := gzip.NewWriter(&wbuf)
-	tw := tar.NewWriter(gw)
-	w := &tarGzEntryWriter{entryNum: 123, entryPrfx: "prefix", gzWriter: gw, tarWriter: tw}
-	_ = w.write(want)
-	w.close()
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{
+				Events:           []*api.LogEvent{{Message: "m1", Fields: "pod=p1"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos1", WaitTimeout: streamWaitTimeoutSec},
+			},
+			{
+				Events:           []*api.LogEvent{{Message: "m2"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos2", WaitTimeout: streamWaitTimeoutSec},
+			},
+		},
+		cancel: cancel,
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "stream")}
+
+	rq := httptest.NewRequest("GET", "/v1/stream?limit=10&query=pod:p1", nil)
+	rw := httptest.NewRecorder()
+	if err := s.streamHandler(ctx, rw, rq, nil); err != nil {
+		t.Fatalf("Server.streamHandler() error = %v", err)
+	}
+
+	want := "{\"type\":\"data\",\"payload\":\"m1\",\"tags\":{\"\":\"\"},\"fields\":{\"pod\":\"p1\"}}\n" +
+		"{\"type\":\"data\",\"payload\":\"m2\",\"tags\":{\"\":\"\"},\"fields\":{\"\":\"\"}}\n"
+	if got := rw.Body.String(); got != want {
+		t.Errorf("Server.streamHandler() body = %v, want %v", got, want)
+	}
+	if got := rw.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Server.streamHandler() Content-Type = %v, want application/x-ndjson", got)
+	}
+
+	if len(cli.reqs) != 3 {
+		t.Fatalf("Server.streamHandler() queries = %v, want 3", len(cli.reqs))
+	}
+	first := cli.reqs[0]
+	if first.Pos != "tail" || first.Offset != -10 || first.WaitTimeout != streamWaitTimeoutSec {
+		t.Errorf("Server.streamHandler() first query = %v", &first)
+	}
+	if cli.reqs[1].Pos != "pos1" || cli.reqs[2].Pos != "pos2" {
+		t.Errorf("Server.streamHandler() next queries = %v, %v", &cli.reqs[1], &cli.reqs[2])
+	}
+}
+
+func TestServer_downloadHandler(t *testing.T) {
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{Events: []*api.LogEvent{{Timestamp: time.Date(2019, time.January, 1, 1,
+				1, 1, 0, time.UTC).UnixNano(), Message: "m1", Fields: "pod=p1"}}},
+			{},
+		},
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "download")}
 
-	//read
-	gzReader, _ := gzip.NewReader(&wbuf)
+	rq := httptest.NewRequest("GET", "/v1/download?query=pod:p1", nil)
+	rw := httptest.NewRecorder()
+	if err := s.downloadHandler(context.Background(), rw, rq, nil); err != nil {
+		t.Fatalf("Server.downloadHandler() error = %v", err)
+	}
+
+	wantQuery := "SELECT FROM partition WHERE fields:pod=\"p1\" LIMIT 500000000"
+	if len(cli.reqs) == 0 || cli.reqs[0].Query != wantQuery || cli.reqs[0].Pos != "head" {
+		t.Fatalf("Server.downloadHandler() queries = %v, want %v", cli.reqs, wantQuery)
+	}
+
+	gzReader, err := gzip.NewReader(rw.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
 	tarReader := tar.NewReader(gzReader)
-	h, _ := tarReader.Next()
-	rbuf := make([]byte, h.Size)
-	_, _ = tarReader.Read(rbuf)
+	h, err := tarReader.Next()
+	if err != nil {
+		t.Fatalf("tar.Reader.Next() error = %v", err)
+	}
+	got := make([]byte, h.Size)
+	_, _ = tarReader.Read(got)
 
-	//check
-	if !reflect.DeepEqual(rbuf, want) {
-		t.Errorf("tarGzEntryWriter.write() = %v, want %v", rbuf, want)
+	want := "{\"ts\":\"2019-01-01T01:01:01Z\", \"tags\":\"\", \"fields\":\"pod=p1\", \"msg\":\"m1\"}\n"
+	if h.Name != downloadFilenamePrfx || string(got) != want {
+		t.Errorf("Server.downloadHandler() = %v: %v, want %v: %v", h.Name, string(got), downloadFilenamePrfx, want)
+	}
+}
+
+func TestServer_downloadHandler_ndjsonGz(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []*api.QueryResult
+		want    string
+	}{
+		{
+			name: "entries",
+			results: []*api.QueryResult{
+				{Events: []*api.LogEvent{{Timestamp: time.Date(2019, time.January, 1, 1,
+					1, 1, 0, time.UTC).UnixNano(), Message: "m1", Fields: "pod=p1"}}},
+				{},
+			},
+			want: "{\"ts\":\"2019-01-01T01:01:01Z\", \"tags\":\"\", \"fields\":\"pod=p1\", \"msg\":\"m1\"}\n",
+		},
+		{
+			name:    "no entries",
+			results: []*api.QueryResult{{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &testLrClient{results: tt.results}
+			s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "download")}
+
+			rq := httptest.NewRequest("GET", "/v1/download", nil)
+			rq.Header.Set("Accept", "application/x-ndjson")
+			rw := httptest.NewRecorder()
+			if err := s.downloadHandler(context.Background(), rw, rq, nil); err != nil {
+				t.Fatalf("Server.downloadHandler() error = %v", err)
+			}
+
+			// the body is the gzip file of NDJSON, it's not encoded for the transfer
+			if got := rw.Header().Get("Content-Type"); got != "application/gzip" {
+				t.Errorf("Server.downloadHandler() Content-Type = %v, want application/gzip", got)
+			}
+			if got := rw.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Server.downloadHandler() Content-Encoding = %v, want none", got)
+			}
+			gzReader, err := gzip.NewReader(rw.Body)
+			if err != nil {
+				t.Fatalf("gzip.NewReader() error = %v", err)
+			}
+			got, err := ioutil.ReadAll(gzReader)
+			if err != nil {
+				t.Fatalf("gzip.Reader.Read() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Server.downloadHandler() = %v, want %v", string(got), tt.want)
+			}
+		})
 	}
 }
@@ -0,0 +1,267 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"github.com/logrange/logrange/pkg/utils"
+)
+
+type (
+	// Authenticator authenticates incoming API requests
+	Authenticator interface {
+		// Authenticate returns nil if the request is authenticated, otherwise
+		// the error is returned which is either unauthenticated one (see IsUnauthenticated),
+		// when the request has no valid credentials, or trace.AccessDenied
+		// when the credentials are valid but not allowed to access the API.
+		Authenticate(rq *http.Request) error
+	}
+
+	// Represents API authentication config, the request is authenticated
+	// if any of the configured methods succeeds. If none of the methods
+	// is configured, the authentication is disabled.
+	AuthConfig struct {
+		// Path to the file with allowed bearer tokens, one token per line,
+		// empty lines and lines starting with '#' are ignored
+		TokensFile string
+		// Path to the CA certificate(s) file used to verify client certificates
+		ClientCAFile string
+		// Allowed client certificates common names, any if empty
+		ClientCommonNames []string
+	}
+
+	// Authenticates requests by the static bearer tokens
+	tokenAuthenticator struct {
+		tokens []string
+	}
+
+	// Authenticates requests by the TLS client certificates
+	certAuthenticator struct {
+		roots       *x509.CertPool
+		commonNames map[string]bool
+	}
+
+	// Authenticates requests by any of the given authenticators
+	anyAuthenticator []Authenticator
+
+	// Authenticates requests by all the given authenticators
+	allAuthenticator []Authenticator
+
+	// Represents authentication failure, results in 401 HTTP code
+	unauthenticatedError struct {
+		trace.AccessDeniedError
+	}
+)
+
+const (
+	// Value of "WWW-Authenticate" header returned along with 401 HTTP code
+	authChallenge = `Bearer realm="logging-app"`
+)
+
+// NewAuthenticator creates authenticator for the given config,
+// it returns nil if no authentication method is configured
+func NewAuthenticator(cfg *AuthConfig) (Authenticator, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var auths anyAuthenticator
+	if cfg.TokensFile != "" {
+		a, err := newTokenAuthenticator(cfg.TokensFile)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		auths = append(auths, a)
+	}
+	if cfg.ClientCAFile != "" {
+		a, err := newCertAuthenticator(cfg.ClientCAFile, cfg.ClientCommonNames)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		auths = append(auths, a)
+	}
+
+	switch len(auths) {
+	case 0:
+		return nil, nil
+	case 1:
+		return auths[0], nil
+	}
+	return auths, nil
+}
+
+// Unauthenticated returns authentication failure error
+func Unauthenticated(message string, args ...interface{}) error {
+	return trace.Wrap(&unauthenticatedError{
+		AccessDeniedError: trace.AccessDeniedError{Message: fmt.Sprintf(message, args...)},
+	})
+}
+
+// IsUnauthenticated returns true if the given error is authentication failure
+func IsUnauthenticated(err error) bool {
+	_, ok := trace.Unwrap(err).(*unauthenticatedError)
+	return ok
+}
+
+func newTokenAuthenticator(tokensFile string) (*tokenAuthenticator, error) {
+	f, err := os.Open(tokensFile)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	a := &tokenAuthenticator{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		a.tokens = append(a.tokens, token)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(a.tokens) == 0 {
+		return nil, trace.BadParameter("no tokens found in %v", tokensFile)
+	}
+	return a, nil
+}
+
+func (a *tokenAuthenticator) Authenticate(rq *http.Request) error {
+	h := rq.Header.Get("Authorization")
+	if h == "" {
+		return Unauthenticated("bearer token required")
+	}
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return Unauthenticated("unsupported authorization scheme")
+	}
+
+	token := []byte(strings.TrimSpace(parts[1]))
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			return nil
+		}
+	}
+	return Unauthenticated("invalid bearer token")
+}
+
+func newCertAuthenticator(caFile string, commonNames []string) (*certAuthenticator, error) {
+	a := &certAuthenticator{roots: x509.NewCertPool(), commonNames: make(map[string]bool)}
+	if err := appendCertsFromFile(a.roots, caFile); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, cn := range commonNames {
+		a.commonNames[cn] = true
+	}
+	return a, nil
+}
+
+func (a *certAuthenticator) Authenticate(rq *http.Request) error {
+	if rq.TLS == nil || len(rq.TLS.PeerCertificates) == 0 {
+		return Unauthenticated("client certificate required")
+	}
+
+	certs := rq.TLS.PeerCertificates
+	opts := x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return Unauthenticated("invalid client certificate: %v", err)
+	}
+
+	cn := certs[0].Subject.CommonName
+	if len(a.commonNames) > 0 && !a.commonNames[cn] {
+		return trace.AccessDenied("client certificate common name %q is not allowed", cn)
+	}
+	return nil
+}
+
+// Authenticate returns nil if all the authenticators succeed,
+// otherwise the first error is returned
+func (auths allAuthenticator) Authenticate(rq *http.Request) error {
+	for _, a := range auths {
+		if err := a.Authenticate(rq); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// Authenticate returns nil if any of the authenticators succeeds,
+// otherwise access denied error takes precedence over unauthenticated one,
+// since it means that valid credentials are given
+func (auths anyAuthenticator) Authenticate(rq *http.Request) error {
+	var errs []error
+	for _, a := range auths {
+		err := a.Authenticate(rq)
+		if err == nil {
+			return nil
+		}
+		if !IsUnauthenticated(err) {
+			return trace.Wrap(err)
+		}
+		errs = append(errs, err)
+	}
+	return Unauthenticated("%v", trace.NewAggregate(errs...))
+}
+
+// Merges current config with the given one
+func (cfg *AuthConfig) Merge(other *AuthConfig) {
+	if other == nil {
+		return
+	}
+
+	if other.TokensFile != "" {
+		cfg.TokensFile = other.TokensFile
+	}
+	if other.ClientCAFile != "" {
+		cfg.ClientCAFile = other.ClientCAFile
+	}
+	if len(other.ClientCommonNames) > 0 {
+		cfg.ClientCommonNames = other.ClientCommonNames
+	}
+}
+
+// Checks whether current config is valid and safe to use
+func (cfg *AuthConfig) Check() error {
+	if len(cfg.ClientCommonNames) > 0 && cfg.ClientCAFile == "" {
+		return trace.BadParameter("invalid ClientCommonNames: ClientCAFile must be non-empty")
+	}
+	if _, err := NewAuthenticator(cfg); err != nil {
+		return trace.BadParameter("invalid auth config: %v", err)
+	}
+	return nil
+}
+
+func (cfg *AuthConfig) String() string {
+	return utils.ToJsonStr(cfg)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+)
+
+func Test_tokenAuthenticator(t *testing.T) {
+	f := writeTmpFile(t, "# comment\n\ntoken1\n token2 \n")
+	defer os.Remove(f)
+
+	auth, err := NewAuthenticator(&AuthConfig{TokensFile: f})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	tests := []struct {
+		header string
+		wantOk bool
+	}{
+		{header: "Bearer token1", wantOk: true},
+		{header: "bearer token2", wantOk: true},
+		{header: "Bearer token3"},
+		{header: "Basic dXNlcjpwYXNz"},
+		{header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/v1/log", nil)
+			rq.Header.Set("Authorization", tt.header)
+			err := auth.Authenticate(rq)
+			if tt.wantOk != (err == nil) || (err != nil && !IsUnauthenticated(err)) {
+				t.Errorf("tokenAuthenticator.Authenticate() error = %v, wantOk %v", err, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_certAuthenticator(t *testing.T) {
+	caCert, caKey := newTestCert(t, "ca", nil, nil)
+	okCert, _ := newTestCert(t, "client1", caCert, caKey)
+	cnCert, _ := newTestCert(t, "client2", caCert, caKey)
+	badCert, _ := newTestCert(t, "client1", nil, nil)
+
+	f := writeTmpFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})))
+	defer os.Remove(f)
+
+	auth, err := NewAuthenticator(&AuthConfig{ClientCAFile: f, ClientCommonNames: []string{"client1"}})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		wantErr func(error) bool
+	}{
+		{name: "valid cert ok", cert: okCert},
+		{name: "not allowed common name err", cert: cnCert, wantErr: trace.IsAccessDenied},
+		{name: "unknown CA err", cert: badCert, wantErr: IsUnauthenticated},
+		{name: "no cert err", wantErr: IsUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/v1/log", nil)
+			if tt.cert != nil {
+				rq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			err := auth.Authenticate(rq)
+			if (tt.wantErr == nil && err != nil) || (tt.wantErr != nil && !tt.wantErr(err)) {
+				t.Errorf("certAuthenticator.Authenticate() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_anyAuthenticator(t *testing.T) {
+	unauth := authFunc(func(*http.Request) error { return Unauthenticated("no") })
+	denied := authFunc(func(*http.Request) error { return trace.AccessDenied("denied") })
+	ok := authFunc(func(*http.Request) error { return nil })
+
+	rq := httptest.NewRequest("GET", "/v1/log", nil)
+	if err := (anyAuthenticator{unauth, ok}).Authenticate(rq); err != nil {
+		t.Errorf("anyAuthenticator.Authenticate() error = %v, want nil", err)
+	}
+	if err := (anyAuthenticator{unauth, denied}).Authenticate(rq); !trace.IsAccessDenied(err) || IsUnauthenticated(err) {
+		t.Errorf("anyAuthenticator.Authenticate() error = %v, want access denied", err)
+	}
+	if err := (anyAuthenticator{unauth, unauth}).Authenticate(rq); !IsUnauthenticated(err) {
+		t.Errorf("anyAuthenticator.Authenticate() error = %v, want unauthenticated", err)
+	}
+}
+
+func TestServer_withAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		authErr    error
+		wantStatus int
+	}{
+		{name: "authenticated ok", wantStatus: http.StatusOK},
+		{name: "unauthenticated err", authErr: Unauthenticated("no"), wantStatus: http.StatusUnauthorized},
+		{name: "access denied err", authErr: trace.AccessDenied("denied"), wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				auth:   authFunc(func(*http.Request) error { return tt.authErr }),
+				logger: log.WithField("test", "auth"),
+			}
+			h := s.withAuth(s.makeHandlerWithCtx(context.Background(), func(ctx context.Context,
+				w http.ResponseWriter, r *http.Request, p httprouter.Params) error {
+				_, err := w.Write([]byte("ok"))
+				return err
+			}))
+
+			rw := httptest.NewRecorder()
+			h(rw, httptest.NewRequest("GET", "/v1/log", nil), nil)
+			if rw.Code != tt.wantStatus {
+				t.Errorf("withAuth() status = %v, want %v", rw.Code, tt.wantStatus)
+			}
+			if gotChallenge := rw.Header().Get("WWW-Authenticate") != ""; gotChallenge != (tt.wantStatus == http.StatusUnauthorized) {
+				t.Errorf("withAuth() WWW-Authenticate = %q", rw.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// Authenticator which calls the given function
+func TestServer_newRouter_metricsAuth(t *testing.T) {
+	for authErr, wantStatus := range map[error]int{
+		nil:                   http.StatusOK,
+		Unauthenticated("no"): http.StatusUnauthorized,
+	} {
+		authErr := authErr
+		s := &Server{
+			auth:   authFunc(func(*http.Request) error { return authErr }),
+			logger: log.WithField("test", "auth"),
+		}
+		rw := httptest.NewRecorder()
+		s.newRouter(context.Background()).ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		if rw.Code != wantStatus {
+			t.Errorf("newRouter() /metrics status = %v, want %v (auth err = %v)", rw.Code, wantStatus, authErr)
+		}
+	}
+}
+
+type authFunc func(rq *http.Request) error
+
+func (f authFunc) Authenticate(rq *http.Request) error {
+	return f(rq)
+}
+
+func writeTmpFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile(os.TempDir(), "test-")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile() error = %v", err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatalf("File.WriteString() error = %v", err)
+	}
+	return f.Name()
+}
+
+// Creates certificate with the given common name, signed by the given parent,
+// if parent is nil, the certificate is self-signed CA
+func newTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() error = %v", err)
+	}
+	return cert, key
+}
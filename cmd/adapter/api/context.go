@@ -0,0 +1,207 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Kind of the event in the log response with context lines
+	eventMark int
+
+	// Identifies the log event
+	eventKey struct {
+		ts     int64
+		fields string
+		msg    string
+	}
+
+	// Matched log events along with their context lines,
+	// the events are kept in the order they are added
+	contextEvents struct {
+		evs   []*api.LogEvent
+		marks []eventMark
+		// index of the added events
+		idx map[eventKey]int
+	}
+)
+
+const (
+	// The event matches the query
+	markMatch eventMark = iota
+	// The event is the context line of a match
+	markContext
+)
+
+const (
+	// Maximum number of context lines before/after each match
+	contextLinesMax = 100
+
+	// Maximum number of matches, when context lines are requested,
+	// each match costs up to 2 Logrange queries (before and after),
+	// so the request makes up to 2*contextMatchesMax+1 queries
+	contextMatchesMax = 20
+)
+
+func (m eventMark) String() string {
+	if m == markContext {
+		return "context"
+	}
+	return "match"
+}
+
+// Returns grep-like separator of the event timestamp and message
+func (m eventMark) separator() byte {
+	if m == markContext {
+		return '-'
+	}
+	return ':'
+}
+
+// Returns the number of context lines requested by the given param ("before" or "after")
+func getContextParam(rq *http.Request, name string) (int, error) {
+	v := strings.TrimSpace(rq.URL.Query().Get(name))
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > contextLinesMax {
+		return 0, trace.BadParameter("invalid %v=%q: must be integer in [0, %v]", name, v, contextLinesMax)
+	}
+	return n, nil
+}
+
+// Adds the event with the given mark, if the event is already added
+// as context and now it's a match, it's re-marked as match
+func (ce *contextEvents) add(e *api.LogEvent, mark eventMark) {
+	key := eventKey{ts: e.Timestamp, fields: e.Fields, msg: e.Message}
+	if i, ok := ce.idx[key]; ok {
+		if mark == markMatch {
+			ce.marks[i] = markMatch
+		}
+		return
+	}
+	ce.idx[key] = len(ce.evs)
+	ce.evs = append(ce.evs, e)
+	ce.marks = append(ce.marks, mark)
+}
+
+// Returns the given matches along with 'before' and 'after' context
+// lines of each match, the context lines are the neighbouring events
+// of the same stream (pod and container) as the match
+func (s *Server) withContext(ctx context.Context, matches []*api.LogEvent,
+	before, after int) (*contextEvents, error) {
+	ce := &contextEvents{idx: make(map[eventKey]int)}
+	for _, m := range matches {
+		evs, err := s.queryContext(ctx, m, before, true)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, e := range evs {
+			ce.add(e, markContext)
+		}
+
+		ce.add(m, markMatch)
+
+		evs, err = s.queryContext(ctx, m, after, false)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, e := range evs {
+			ce.add(e, markContext)
+		}
+	}
+	return ce, nil
+}
+
+// Returns up to n events of the same stream as the given match,
+// which precede (if backward) or follow (otherwise) the match
+func (s *Server) queryContext(ctx context.Context, match *api.LogEvent, n int, backward bool) ([]*api.LogEvent, error) {
+	q := streamQuery(match)
+	if n == 0 || q == "" {
+		return nil, nil
+	}
+
+	// the events with the same timestamp as the match are
+	// selected too, so one more event is needed to skip the match itself
+	ts := time.Unix(0, match.Timestamp)
+	qr := s.buildQueryRequest(q, query.Options{}, query.TimeRange{Since: ts}, "head", n+1, 0)
+	if backward {
+		qr = s.buildQueryRequest(q, query.Options{}, query.TimeRange{Until: ts}, "tail", n+1, -(n + 1))
+	}
+	s.logger.Debug("log(): Context query=", qr.Query)
+
+	res := &api.QueryResult{}
+	if err := s.lrClient.Query(ctx, qr, res); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if res.Err != nil {
+		return nil, trace.Wrap(res.Err)
+	}
+	return contextSlice(res.Events, match, n, backward), nil
+}
+
+// Returns up to n events preceding (if backward) or following (otherwise)
+// the match in the given events, if the match is not found, the events
+// closest to the match are returned
+func contextSlice(evs []*api.LogEvent, match *api.LogEvent, n int, backward bool) []*api.LogEvent {
+	pos := -1
+	for i, e := range evs {
+		if e.Timestamp == match.Timestamp && e.Fields == match.Fields && e.Message == match.Message {
+			pos = i
+			break
+		}
+	}
+
+	if backward {
+		if pos < 0 {
+			pos = len(evs)
+		}
+		if pos > n {
+			return evs[pos-n : pos]
+		}
+		return evs[:pos]
+	}
+
+	evs = evs[pos+1:]
+	if len(evs) > n {
+		return evs[:n]
+	}
+	return evs
+}
+
+// Returns Gravity log query selecting the stream (pod and container)
+// of the given event, empty string is returned if the event has no stream
+func streamQuery(e *api.LogEvent) string {
+	fields := parseCSVIntoMap(e.Fields)
+	var conds []string
+	for _, key := range []string{"pod", "container"} {
+		if v := fields[query.KeyToField(key)]; v != "" {
+			conds = append(conds, key+":"+strconv.Quote(v))
+		}
+	}
+	return strings.Join(conds, " and ")
+}
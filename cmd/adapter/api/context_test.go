@@ -0,0 +1,129 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	log "github.com/gravitational/logrus"
+	"github.com/logrange/logrange/api"
+)
+
+func Test_contextSlice(t *testing.T) {
+	e := func(ts int64) *api.LogEvent { return &api.LogEvent{Timestamp: ts, Message: "m"} }
+	evs := []*api.LogEvent{e(1), e(2), e(3), e(4), e(5)}
+	tests := []struct {
+		name     string
+		match    *api.LogEvent
+		n        int
+		backward bool
+		want     []*api.LogEvent
+	}{
+		{name: "before", match: e(4), n: 2, backward: true, want: []*api.LogEvent{evs[1], evs[2]}},
+		{name: "before head", match: e(2), n: 2, backward: true, want: []*api.LogEvent{evs[0]}},
+		{name: "before not found", match: e(9), n: 2, backward: true, want: []*api.LogEvent{evs[3], evs[4]}},
+		{name: "after", match: e(2), n: 2, want: []*api.LogEvent{evs[2], evs[3]}},
+		{name: "after tail", match: e(4), n: 2, want: []*api.LogEvent{evs[4]}},
+		{name: "after not found", match: e(0), n: 2, want: []*api.LogEvent{evs[0], evs[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contextSlice(evs, tt.match, tt.n, tt.backward); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("contextSlice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_streamQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		want   string
+	}{
+		{name: "pod and container", fields: "pod=p1,ns=n1,cname=c\"1", want: "(fields:pod=\"p1\" AND fields:cname=\"c\\\"1\")"},
+		{name: "pod", fields: "pod=p1", want: "fields:pod=\"p1\""},
+		{name: "no stream", fields: "file=f1", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := streamQuery(&api.LogEvent{Fields: tt.fields})
+			want := "SELECT FROM p LIMIT 1"
+			if tt.want != "" {
+				want = "SELECT FROM p WHERE " + tt.want + " LIMIT 1"
+			}
+			if got := query.BuildLqlQuery(q, query.Options{}, "p", query.TimeRange{}, 1, 0); got != want {
+				t.Errorf("streamQuery() = %v, LQL = %v, want %v", q, got, want)
+			}
+		})
+	}
+}
+
+func TestServer_withContext(t *testing.T) {
+	e := func(ts int64) *api.LogEvent { return &api.LogEvent{Timestamp: ts, Fields: "pod=p1", Message: "m"} }
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			// before the 1st match
+			{Events: []*api.LogEvent{e(1), e(2), e(3)}},
+			// after the 1st match
+			{Events: []*api.LogEvent{e(3), e(4), e(5)}},
+			// before the 2nd match, it was the context of the 1st one
+			{Events: []*api.LogEvent{e(3), e(4), e(5)}},
+			// after the 2nd match
+			{Events: []*api.LogEvent{e(5), e(6)}},
+		},
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "context")}
+
+	ce, err := s.withContext(context.Background(), []*api.LogEvent{e(3), e(5)}, 2, 1)
+	if err != nil {
+		t.Fatalf("Server.withContext() error = %v", err)
+	}
+
+	wantEvs := []*api.LogEvent{e(1), e(2), e(3), e(4), e(5), e(6)}
+	wantMarks := []eventMark{markContext, markContext, markMatch, markContext, markMatch, markContext}
+	if !reflect.DeepEqual(ce.evs, wantEvs) || !reflect.DeepEqual(ce.marks, wantMarks) {
+		t.Errorf("Server.withContext() = %v %v, want %v %v", ce.evs, ce.marks, wantEvs, wantMarks)
+	}
+
+	wantQueries := []string{
+		"SELECT FROM partition WHERE fields:pod=\"p1\" AND ts <= \"3\" OFFSET -3 LIMIT 3",
+		"SELECT FROM partition WHERE fields:pod=\"p1\" AND ts >= \"3\" LIMIT 2",
+	}
+	if len(cli.reqs) != 4 || cli.reqs[0].Query != wantQueries[0] || cli.reqs[0].Pos != "tail" ||
+		cli.reqs[1].Query != wantQueries[1] || cli.reqs[1].Pos != "head" {
+		t.Errorf("Server.withContext() queries = %v, want %v", cli.reqs, wantQueries)
+	}
+}
+
+func TestServer_logHandler_contextLimit(t *testing.T) {
+	cli := &testLrClient{results: []*api.QueryResult{{Events: []*api.LogEvent{{Message: "m1"}}}, {}}}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "context")}
+
+	// each match costs up to 2 context queries, so the number of matches is capped
+	if err := s.logHandler(context.Background(), httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/v1/log?limit=1000&before=1&after=1", nil), nil); err != nil {
+		t.Fatalf("Server.logHandler() error = %v", err)
+	}
+	if len(cli.reqs) == 0 || cli.reqs[0].Limit != contextMatchesMax {
+		t.Errorf("Server.logHandler() queries = %v, want Limit=%v", cli.reqs, contextMatchesMax)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+)
+
+type (
+	// Represents "/v1/log" page position, it's opaque for the API clients
+	// which get it in the response header and pass it back as 'cursor' param
+	// in order to get the next (either older or newer) page.
+	logCursor struct {
+		// Logrange position right after the last entry of the page
+		Pos string `json:"pos"`
+		// Number of the entries in the page
+		Count int `json:"count"`
+	}
+)
+
+const (
+	// Response header which contains the cursor of the returned page
+	logCursorHeader = "X-Log-Cursor"
+
+	// Page direction towards older entries
+	directionBackward = "backward"
+
+	// Page direction towards newer entries
+	directionForward = "forward"
+)
+
+// Decodes cursor from the given string returned by encode()
+func decodeLogCursor(s string) (*logCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, trace.BadParameter("invalid cursor=%q: %v", s, err)
+	}
+	cur := &logCursor{}
+	if err = json.Unmarshal(b, cur); err != nil || cur.Pos == "" || cur.Count < 0 {
+		return nil, trace.BadParameter("invalid cursor=%q", s)
+	}
+	return cur, nil
+}
+
+// Encodes cursor to the URL safe string
+func (c *logCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Returns offset relative to the cursor position, which is
+// needed to read the page of the given size in the given direction
+func (c *logCursor) offset(direction string, limit int) (int, error) {
+	switch direction {
+	case "", directionBackward:
+		return -(c.Count + limit), nil
+	case directionForward:
+		return 0, nil
+	}
+	return 0, trace.BadParameter("invalid direction=%q: must be %q or %q",
+		direction, directionBackward, directionForward)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"testing"
+)
+
+func Test_logCursor_encode(t *testing.T) {
+	want := &logCursor{Pos: "pos1", Count: 10}
+	got, err := decodeLogCursor(want.encode())
+	if err != nil {
+		t.Fatalf("decodeLogCursor() error = %v", err)
+	}
+	if *got != *want {
+		t.Errorf("decodeLogCursor() = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"", "!", "e30"} { // "e30" is encoded "{}"
+		if _, err := decodeLogCursor(s); err == nil {
+			t.Errorf("decodeLogCursor(%q) expected error", s)
+		}
+	}
+}
+
+func Test_logCursor_offset(t *testing.T) {
+	cur := &logCursor{Pos: "pos1", Count: 10}
+	tests := []struct {
+		direction string
+		want      int
+		wantErr   bool
+	}{
+		{direction: "", want: -110},
+		{direction: directionBackward, want: -110},
+		{direction: directionForward, want: 0},
+		{direction: "sideways", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.direction, func(t *testing.T) {
+			got, err := cur.offset(tt.direction, 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logCursor.offset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("logCursor.offset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+)
+
+// "/v1/explain" api handler, explains how the given 'query' param is translated
+// to LQL query, i.e. returns the parsed query, the resulting LQL query and
+// whether the query is used as literal text (and why). Params 'limit', 'since'
+// and 'until' are the same as for "/v1/log" and affect the resulting LQL query only.
+func (s *Server) explainHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	queryParam := strings.TrimSpace(rq.URL.Query().Get("query"))
+	limit := s.getLimitParam(rq, "explain()")
+	tr, err := getTimeRangeParams(rq, time.Now())
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return writeJson(rw, query.Explain(queryParam, getQueryOptions(rq), s.lrPartition, tr, limit, defaultTailLinesOffset))
+}
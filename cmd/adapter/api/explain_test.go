@@ -0,0 +1,40 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/gravitational/logrus"
+)
+
+func TestServer_explainHandler(t *testing.T) {
+	s := &Server{lrPartition: "partition", logger: log.WithField("test", "explain")}
+	rw := httptest.NewRecorder()
+	rq := httptest.NewRequest("GET", "/v1/explain?query=pod:p1&limit=10", nil)
+	if err := s.explainHandler(context.Background(), rw, rq, nil); err != nil {
+		t.Fatalf("Server.explainHandler() error = %v", err)
+	}
+
+	want := `{"query":"pod:p1","ast":{"type":"condition","key":"pod","value":"p1","field":"pod"},` +
+		`"lql":"SELECT FROM partition WHERE fields:pod=\"p1\" OFFSET -1000 LIMIT 10","fallback":false}`
+	if rw.Body.String() != want {
+		t.Errorf("Server.explainHandler() = %v, want %v", rw.Body.String(), want)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Cache of the field names and their distinct values
+	// seen in the recent events of the partition
+	fieldsCache struct {
+		lock sync.Mutex
+		// Distinct values per field name
+		values map[string]map[string]struct{}
+		// Time after which the cache must be refreshed
+		expiresAt time.Time
+		// Closed when the running refresh is done, nil if the cache is not being refreshed
+		refreshDone chan struct{}
+		// Error of the last refresh, if any
+		refreshErr error
+	}
+
+	// Represents the field in "/v1/fields" response
+	fieldInfo struct {
+		Name string `json:"name"`
+		// 'Gravity log query' key of the field, if any
+		Key string `json:"key,omitempty"`
+		// Number of distinct values
+		Values int `json:"values"`
+	}
+
+	// Represents "/v1/fields" response
+	fieldsResponse struct {
+		Fields []fieldInfo `json:"fields"`
+	}
+
+	// Represents "/v1/fields/{name}/values" response
+	fieldValuesResponse struct {
+		Name   string   `json:"name"`
+		Values []string `json:"values"`
+	}
+)
+
+const (
+	// Fields cache time to live
+	fieldsCacheTTL = 30 * time.Second
+
+	// Number of the most recent events which are scanned to collect fields
+	fieldsScanLinesLimit = 10000
+
+	// Maximum number of distinct values collected per field
+	fieldValuesMax = 10000
+
+	// Field values default limit
+	defaultFieldValuesLimit = 100
+)
+
+// "/v1/fields" api handler, returns the field names
+// seen in the recent events, sorted by name
+func (s *Server) fieldsHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	values, err := s.getFieldValues(ctx, rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	res := fieldsResponse{Fields: make([]fieldInfo, 0, len(values))}
+	for name, vals := range values {
+		res.Fields = append(res.Fields, fieldInfo{Name: name, Key: query.FieldToKey(name), Values: len(vals)})
+	}
+	sort.Slice(res.Fields, func(i, j int) bool { return res.Fields[i].Name < res.Fields[j].Name })
+	return writeJson(rw, res)
+}
+
+// "/v1/fields/{name}/values" api handler, returns the distinct values
+// of the field seen in the recent events, sorted and filtered
+// by 'prefix' param. The field can be specified either by
+// its name (e.g. "cname") or 'Gravity log query' key (e.g. "container")
+func (s *Server) fieldValuesHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	limit, err := getFieldValuesLimitParam(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	name := p.ByName("name")
+	if f := query.KeyToField(name); f != "" {
+		name = f
+	}
+	prefix := rq.URL.Query().Get("prefix")
+
+	values, err := s.getFieldValues(ctx, rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	res := fieldValuesResponse{Name: name, Values: []string{}}
+	for v := range values[name] {
+		if strings.HasPrefix(v, prefix) {
+			res.Values = append(res.Values, v)
+		}
+	}
+	sort.Strings(res.Values)
+	if limit > 0 && len(res.Values) > limit {
+		res.Values = res.Values[:limit]
+	}
+	return writeJson(rw, res)
+}
+
+// Returns 'limit' request param of field values, 0 means no limit
+func getFieldValuesLimitParam(rq *http.Request) (int, error) {
+	v := strings.TrimSpace(rq.URL.Query().Get("limit"))
+	if v == "" {
+		return defaultFieldValuesLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, trace.BadParameter("invalid limit: %q is not non-negative integer", v)
+	}
+	return limit, nil
+}
+
+// Returns the cached field values, starts the cache refresh if it's expired.
+// The expired values are returned while the cache is being refreshed, so only
+// the requests to the empty cache wait for the refresh (at most one at a time).
+// The returned map must not be modified.
+func (s *Server) getFieldValues(ctx context.Context, rq *http.Request) (map[string]map[string]struct{}, error) {
+	s.fields.lock.Lock()
+	values, done := s.fields.values, s.fields.refreshDone
+	if (values == nil || !time.Now().Before(s.fields.expiresAt)) && done == nil {
+		done = make(chan struct{})
+		s.fields.refreshDone = done
+		// the refresh is not bound to the request, so the other requests get its result
+		// even if the client goes away
+		go s.refreshFieldValues(ctx, done)
+	}
+	s.fields.lock.Unlock()
+	if values != nil {
+		return values, nil
+	}
+
+	select {
+	case <-done:
+	case <-rq.Context().Done():
+		return nil, trace.Wrap(rq.Context().Err())
+	}
+	s.fields.lock.Lock()
+	defer s.fields.lock.Unlock()
+	if s.fields.values == nil {
+		return nil, trace.Wrap(s.fields.refreshErr)
+	}
+	return s.fields.values, nil
+}
+
+// Scans the field values and swaps them into the cache, closes done when it's finished
+func (s *Server) refreshFieldValues(ctx context.Context, done chan struct{}) {
+	values, err := s.scanFieldValues(ctx)
+
+	s.fields.lock.Lock()
+	defer s.fields.lock.Unlock()
+	if err != nil {
+		s.logger.Warn("fields(): failed to refresh the cache, err=", err)
+	} else {
+		s.fields.values = values
+		s.fields.expiresAt = time.Now().Add(fieldsCacheTTL)
+	}
+	s.fields.refreshErr = err
+	s.fields.refreshDone = nil
+	close(done)
+}
+
+// Collects the field values from the most recent events of the partition
+func (s *Server) scanFieldValues(ctx context.Context) (map[string]map[string]struct{}, error) {
+	qr := s.buildQueryRequest("", query.Options{}, query.TimeRange{}, "tail", fieldsScanLinesLimit, -fieldsScanLinesLimit)
+	s.logger.Info("fields(): Query=", qr.Query)
+
+	values := make(map[string]map[string]struct{})
+	err := api.Select(ctx, s.lrClient, qr, false,
+		func(res *api.QueryResult) {
+			for _, e := range res.Events {
+				for name, v := range parseCSVIntoMap(e.Fields) {
+					if name == "" {
+						continue
+					}
+					vals, ok := values[name]
+					if !ok {
+						vals = make(map[string]struct{})
+						values[name] = vals
+					}
+					if len(vals) < fieldValuesMax {
+						vals[v] = struct{}{}
+					}
+				}
+			}
+		})
+	return values, trace.Wrap(err)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+func TestServer_fieldsHandler(t *testing.T) {
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{Events: []*api.LogEvent{
+				{Fields: "pod=p1,ns=kube-system,cname=c1"},
+				{Fields: "pod=p2,ns=kube-system,cname=c1"},
+				{Fields: "file=f1"},
+				{},
+			}},
+			{},
+		},
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "fields")}
+
+	rw := httptest.NewRecorder()
+	if err := s.fieldsHandler(context.Background(), rw, httptest.NewRequest("GET", "/v1/fields", nil), nil); err != nil {
+		t.Fatalf("Server.fieldsHandler() error = %v", err)
+	}
+
+	wantQuery := "SELECT FROM partition OFFSET -10000 LIMIT 10000"
+	if len(cli.reqs) != 2 || cli.reqs[0].Query != wantQuery || cli.reqs[0].Pos != "tail" {
+		t.Fatalf("Server.fieldsHandler() queries = %v, want %v", cli.reqs, wantQuery)
+	}
+
+	var got fieldsResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Server.fieldsHandler() body = %s, err = %v", rw.Body.String(), err)
+	}
+	want := fieldsResponse{Fields: []fieldInfo{
+		{Name: "cname", Key: "container", Values: 1},
+		{Name: "file", Values: 1},
+		{Name: "ns", Key: "namespace", Values: 1},
+		{Name: "pod", Key: "pod", Values: 2},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Server.fieldsHandler() = %s, want %v", rw.Body.String(), want)
+	}
+}
+
+func TestServer_fieldValuesHandler(t *testing.T) {
+	s := &Server{logger: log.WithField("test", "fields")}
+	// the cached values, no queries are expected
+	s.fields.values = map[string]map[string]struct{}{
+		"pod":   {"nginx-2": {}, "nginx-1": {}, "coredns": {}},
+		"cname": {"nginx": {}},
+	}
+	s.fields.expiresAt = time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name    string
+		params  string
+		field   string
+		want    fieldValuesResponse
+		wantErr bool
+	}{
+		{
+			name:  "all values",
+			field: "pod",
+			want:  fieldValuesResponse{Name: "pod", Values: []string{"coredns", "nginx-1", "nginx-2"}},
+		},
+		{
+			name:   "prefix and limit",
+			params: "?prefix=nginx&limit=1",
+			field:  "pod",
+			want:   fieldValuesResponse{Name: "pod", Values: []string{"nginx-1"}},
+		},
+		{
+			name:  "gravity key",
+			field: "container",
+			want:  fieldValuesResponse{Name: "cname", Values: []string{"nginx"}},
+		},
+		{
+			name:  "unknown field",
+			field: "node",
+			want:  fieldValuesResponse{Name: "node", Values: []string{}},
+		},
+		{
+			name:    "bad limit",
+			params:  "?limit=-1",
+			field:   "pod",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			err := s.fieldValuesHandler(context.Background(), rw,
+				httptest.NewRequest("GET", "/v1/fields/"+tt.field+"/values"+tt.params, nil),
+				httprouter.Params{{Key: "name", Value: tt.field}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Server.fieldValuesHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got fieldValuesResponse
+			if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Server.fieldValuesHandler() body = %s, err = %v", rw.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Server.fieldValuesHandler() = %s, want %v", rw.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_getFieldValues_refresh(t *testing.T) {
+	var queries int32
+	started, release := make(chan struct{}), make(chan struct{})
+	cli := &testLrClient{query: func(res *api.QueryResult) error {
+		res.Events = nil
+		if atomic.AddInt32(&queries, 1) == 1 {
+			close(started)
+			<-release
+			res.Events = []*api.LogEvent{{Fields: "pod=p1"}}
+		}
+		return nil
+	}}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "fields")}
+	rq := httptest.NewRequest("GET", "/v1/fields", nil)
+
+	// the concurrent requests to the empty cache wait for the single refresh
+	results := make(chan map[string]map[string]struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			values, err := s.getFieldValues(context.Background(), rq)
+			if err != nil {
+				t.Errorf("Server.getFieldValues() error = %v", err)
+			}
+			results <- values
+		}()
+	}
+	<-started
+
+	// the request going away doesn't wait for the refresh
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.getFieldValues(context.Background(), rq.WithContext(cctx)); err == nil {
+		t.Errorf("Server.getFieldValues() error = nil, want canceled")
+	}
+
+	close(release)
+	want := map[string]map[string]struct{}{"pod": {"p1": {}}}
+	for i := 0; i < 3; i++ {
+		if got := <-results; !reflect.DeepEqual(got, want) {
+			t.Errorf("Server.getFieldValues() = %v, want %v", got, want)
+		}
+	}
+	if got := atomic.LoadInt32(&queries); got != 2 {
+		t.Errorf("Server.getFieldValues() queries = %v, want 2", got)
+	}
+
+	// the expired values are returned while the cache is being refreshed
+	s.fields.lock.Lock()
+	s.fields.expiresAt = time.Now().Add(-time.Second)
+	s.fields.lock.Unlock()
+	if got, err := s.getFieldValues(context.Background(), rq); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Server.getFieldValues() = %v, %v, want %v", got, err, want)
+	}
+	s.fields.lock.Lock()
+	done := s.fields.refreshDone
+	s.fields.lock.Unlock()
+	if done != nil {
+		<-done
+	}
+	if got, err := s.getFieldValues(context.Background(), rq); err != nil || len(got) != 0 {
+		t.Errorf("Server.getFieldValues() = %v, %v, want refreshed empty values", got, err)
+	}
+}
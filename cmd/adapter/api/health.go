@@ -0,0 +1,149 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/LK4D4/joincontext"
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// HealthCheck checks the state of the particular adapter component,
+	// returns nil if the component is healthy
+	HealthCheck func(ctx context.Context) error
+
+	// Named health check
+	healthCheck struct {
+		name  string
+		check HealthCheck
+	}
+
+	// Represents health/readiness report, it's JSON marshaled
+	healthReport struct {
+		Status     string                      `json:"status"`
+		Components map[string]*componentReport `json:"components"`
+	}
+
+	// Represents the particular component health
+	componentReport struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
+const (
+	// Health report status values
+	healthStatusOk      = "ok"
+	healthStatusFailure = "failure"
+
+	// Timeout of a single health check
+	healthCheckTimeout = 5 * time.Second
+)
+
+// AddReadyCheck adds the check which is run on every "/readyz" request,
+// it must be called before Serve()
+func (s *Server) AddReadyCheck(name string, check HealthCheck) {
+	s.readyChecks = append(s.readyChecks, healthCheck{name: name, check: check})
+}
+
+// SetProbeListenAddr makes the server to serve the probes ("/healthz" and "/readyz")
+// on the given address over plaintext HTTP in addition to the API address, so that
+// the probes work regardless of the API TLS config, it must be called before Serve()
+func (s *Server) SetProbeListenAddr(addr string) {
+	s.probeServer = &http.Server{Addr: addr}
+}
+
+// Returns the router of the probes only, the handlers are run with the given context
+func (s *Server) newProbeRouter(ctx context.Context) *httprouter.Router {
+	router := httprouter.New()
+	s.addProbeRoutes(ctx, router)
+	return router
+}
+
+// Adds the probes routes to the given router, the probes are not authenticated
+func (s *Server) addProbeRoutes(ctx context.Context, router *httprouter.Router) {
+	s.handlePublic(ctx, router, "/healthz", s.healthHandler)
+	s.handlePublic(ctx, router, "/readyz", s.readyHandler)
+}
+
+// "/healthz" api handler, returns liveness report, which is ok while
+// the server is able to serve requests (dependencies are not checked)
+func (s *Server) healthHandler(ctx context.Context, rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	checks := []healthCheck{{name: "api", check: func(context.Context) error { return nil }}}
+	return s.writeHealthReport(ctx, rw, rq, checks)
+}
+
+// "/readyz" api handler, returns readiness report, which is ok if Logrange
+// responds to queries and all the added ready checks (see AddReadyCheck) pass
+func (s *Server) readyHandler(ctx context.Context, rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	checks := append([]healthCheck{{name: "logrange", check: s.checkLogrange}}, s.readyChecks...)
+	return s.writeHealthReport(ctx, rw, rq, checks)
+}
+
+// Runs the given checks and writes the report, the response
+// code is 200 if all the checks pass, 503 otherwise. The checks are
+// interrupted if either the server is stopped or the request is canceled.
+func (s *Server) writeHealthReport(ctx context.Context, rw http.ResponseWriter,
+	rq *http.Request, checks []healthCheck) error {
+	jctx, jcancel := joincontext.Join(ctx, rq.Context())
+	defer jcancel()
+
+	report := &healthReport{Status: healthStatusOk, Components: make(map[string]*componentReport)}
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(jctx, healthCheckTimeout)
+		err := c.check(cctx)
+		cancel()
+
+		if err != nil {
+			s.logger.Warn("health(): Component=", c.name, " check failed, err=", err)
+			report.Status = healthStatusFailure
+			report.Components[c.name] = &componentReport{Status: healthStatusFailure, Error: err.Error()}
+			continue
+		}
+		report.Components[c.name] = &componentReport{Status: healthStatusOk}
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if report.Status != healthStatusOk {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, err = rw.Write(b)
+	return trace.Wrap(err)
+}
+
+// Runs the cheapest Logrange query (the last entry of the partition)
+func (s *Server) checkLogrange(ctx context.Context) error {
+	qr := s.buildQueryRequest("", query.Options{}, query.TimeRange{}, "tail", 1, -1)
+	res := &api.QueryResult{}
+	if err := s.lrClient.Query(ctx, qr, res); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(res.Err)
+}
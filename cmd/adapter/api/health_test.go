@@ -0,0 +1,161 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/logrange/logrange/api"
+)
+
+func TestServer_readyHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failed := func(context.Context) error { return errors.New("failed") }
+	tests := []struct {
+		name       string
+		lrQuery    func(res *api.QueryResult) error
+		checks     []healthCheck
+		wantStatus int
+		want       healthReport
+	}{
+		{
+			name:       "all ok",
+			lrQuery:    queryErr(nil, nil),
+			checks:     []healthCheck{{name: "sync", check: ok}},
+			wantStatus: http.StatusOK,
+			want: healthReport{Status: healthStatusOk, Components: map[string]*componentReport{
+				"logrange": {Status: healthStatusOk},
+				"sync":     {Status: healthStatusOk},
+			}},
+		},
+		{
+			name:       "logrange unavailable",
+			lrQuery:    queryErr(errors.New("conn refused"), nil),
+			checks:     []healthCheck{{name: "sync", check: ok}},
+			wantStatus: http.StatusServiceUnavailable,
+			want: healthReport{Status: healthStatusFailure, Components: map[string]*componentReport{
+				"logrange": {Status: healthStatusFailure, Error: "conn refused"},
+				"sync":     {Status: healthStatusOk},
+			}},
+		},
+		{
+			name:       "logrange query err",
+			lrQuery:    queryErr(nil, errors.New("no partition")),
+			wantStatus: http.StatusServiceUnavailable,
+			want: healthReport{Status: healthStatusFailure, Components: map[string]*componentReport{
+				"logrange": {Status: healthStatusFailure, Error: "no partition"},
+			}},
+		},
+		{
+			name:       "sync failed",
+			lrQuery:    queryErr(nil, nil),
+			checks:     []healthCheck{{name: "sync", check: failed}},
+			wantStatus: http.StatusServiceUnavailable,
+			want: healthReport{Status: healthStatusFailure, Components: map[string]*componentReport{
+				"logrange": {Status: healthStatusOk},
+				"sync":     {Status: healthStatusFailure, Error: "failed"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				lrClient:    &testLrClient{query: tt.lrQuery},
+				lrPartition: "partition",
+				logger:      log.WithField("test", "ready"),
+			}
+			for _, c := range tt.checks {
+				s.AddReadyCheck(c.name, c.check)
+			}
+
+			rw := httptest.NewRecorder()
+			if err := s.readyHandler(context.Background(), rw, httptest.NewRequest("GET", "/readyz", nil), nil); err != nil {
+				t.Fatalf("readyHandler() error = %v", err)
+			}
+			if rw.Code != tt.wantStatus {
+				t.Errorf("readyHandler() status = %v, want %v", rw.Code, tt.wantStatus)
+			}
+			var got healthReport
+			if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+				t.Fatalf("readyHandler() body = %s, err = %v", rw.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readyHandler() = %s, want %v", rw.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_readyHandler_serverStopped(t *testing.T) {
+	s := &Server{
+		lrClient:    &testLrClient{query: queryErr(nil, nil)},
+		lrPartition: "partition",
+		logger:      log.WithField("test", "ready"),
+	}
+	s.AddReadyCheck("sync", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rw := httptest.NewRecorder()
+	if err := s.readyHandler(ctx, rw, httptest.NewRequest("GET", "/readyz", nil), nil); err != nil {
+		t.Fatalf("readyHandler() error = %v", err)
+	}
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyHandler() status = %v, want %v", rw.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServer_healthHandler(t *testing.T) {
+	s := &Server{logger: log.WithField("test", "health")}
+	rw := httptest.NewRecorder()
+	if err := s.healthHandler(context.Background(), rw, httptest.NewRequest("GET", "/healthz", nil), nil); err != nil {
+		t.Fatalf("healthHandler() error = %v", err)
+	}
+	if rw.Code != http.StatusOK {
+		t.Errorf("healthHandler() status = %v, want %v", rw.Code, http.StatusOK)
+	}
+}
+
+func TestServer_newProbeRouter(t *testing.T) {
+	s := NewServer("", nil, "", authFunc(func(*http.Request) error { return Unauthenticated("denied") }), nil)
+	router := s.newProbeRouter(context.Background())
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if h, _, _ := router.Lookup("GET", path); h == nil {
+			t.Errorf("newProbeRouter() %v is not routed", path)
+		}
+	}
+	for _, path := range []string{"/v1/log", "/metrics"} {
+		if h, _, _ := router.Lookup("GET", path); h != nil {
+			t.Errorf("newProbeRouter() %v is routed, want probes only", path)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Log download layout, groups log events into the download
+	// entries and writes them using the given entry writer
+	entryLayout interface {
+		// Adds the given events, writes the entries which reached the size limit
+		add(evs []*api.LogEvent) error
+		// Writes all the remaining entries
+		flush() error
+	}
+
+	// Writes all the events one after another into the rolling
+	// entries "messages", "messages.0", ..., regardless of their source
+	flatLayout struct {
+		writer entryWriter
+		buf    bytes.Buffer
+	}
+
+	// Writes the events grouped by their source into the rolling
+	// entries "<namespace>/<pod>/<container>.log", "<namespace>/<pod>/<container>.log.0", ...
+	sourceLayout struct {
+		writer entryWriter
+		// buffered events per entry name
+		bufs map[string]*bytes.Buffer
+		// number of the written entries per entry name
+		entryNums map[string]int
+		// total size of the buffered events
+		size int
+	}
+)
+
+const (
+	// Log download layout name, see flatLayout
+	layoutFlat = "flat"
+
+	// Log download layout name, see sourceLayout
+	layoutSource = "source"
+
+	// Log download limit in bytes for all the buffered entries,
+	// when it's exceeded the biggest buffered entry is written
+	downloadBytesBufferedLimit = 64 * 1024 * 1024
+
+	// Log download entry name part, used when the event lacks the corresponding field
+	unknownEntryNamePart = "unknown"
+)
+
+// Creates log download layout by the given name
+func newEntryLayout(name string, writer entryWriter) (entryLayout, error) {
+	switch name {
+	case "", layoutFlat:
+		return &flatLayout{writer: writer}, nil
+	case layoutSource:
+		return &sourceLayout{
+			writer:    writer,
+			bufs:      make(map[string]*bytes.Buffer),
+			entryNums: make(map[string]int),
+		}, nil
+	}
+	return nil, trace.BadParameter("invalid layout=%q: must be %q or %q", name, layoutFlat, layoutSource)
+}
+
+func (l *flatLayout) add(evs []*api.LogEvent) error {
+	writeEvents(evs, &l.buf)
+	if l.buf.Len() > downloadBytesPerFileLimit {
+		return l.flush()
+	}
+	return nil
+}
+
+func (l *flatLayout) flush() error {
+	if l.buf.Len() == 0 {
+		return nil
+	}
+	err := l.writer.write(l.buf.Bytes())
+	l.buf.Reset()
+	return trace.Wrap(err)
+}
+
+func (l *sourceLayout) add(evs []*api.LogEvent) error {
+	for _, e := range evs {
+		name := sourceEntryName(e)
+		buf, ok := l.bufs[name]
+		if !ok {
+			buf = &bytes.Buffer{}
+			l.bufs[name] = buf
+		}
+
+		n := buf.Len()
+		writeEvents([]*api.LogEvent{e}, buf)
+		l.size += buf.Len() - n
+
+		if buf.Len() > downloadBytesPerFileLimit {
+			if err := l.write(name); err != nil {
+				return trace.Wrap(err)
+			}
+		}
+	}
+
+	// too much is buffered, write the biggest entry to free the memory
+	for l.size > downloadBytesBufferedLimit {
+		if err := l.write(l.biggest()); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (l *sourceLayout) flush() error {
+	names := make([]string, 0, len(l.bufs))
+	for name := range l.bufs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := l.write(name); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// Writes the buffered entry and drops the buffer
+func (l *sourceLayout) write(name string) error {
+	buf := l.bufs[name]
+	delete(l.bufs, name)
+	l.size -= buf.Len()
+
+	entryNum := l.entryNums[name]
+	l.entryNums[name]++
+	return trace.Wrap(l.writer.writeEntry(entryName(name, entryNum), buf.Bytes()))
+}
+
+// Returns the name of the biggest buffered entry
+func (l *sourceLayout) biggest() string {
+	var name string
+	size := -1
+	for n, buf := range l.bufs {
+		if buf.Len() > size {
+			name, size = n, buf.Len()
+		}
+	}
+	return name
+}
+
+// Returns the entry name for the given event, it's "<namespace>/<pod>/<container>.log"
+// for the events from k8s containers, otherwise it's either the log file name or
+// the default download file name prefix
+func sourceEntryName(e *api.LogEvent) string {
+	fields := parseCSVIntoMap(e.Fields)
+	pod := fields[query.KeyToField("pod")]
+	cname := fields[query.KeyToField("container")]
+	if pod != "" || cname != "" {
+		return path.Join(entryNamePart(fields[query.KeyToField("namespace")]), entryNamePart(pod),
+			entryNamePart(cname)+".log")
+	}
+	if file := fields["file"]; file != "" {
+		return entryNamePart(file)
+	}
+	return downloadFilenamePrfx
+}
+
+// Makes the given value safe to be a part of entry name (e.g. no path traversal)
+func entryNamePart(v string) string {
+	v = strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(v))
+	if v == "" || v == "." || v == ".." {
+		return unknownEntryNamePart
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/logrange/logrange/api"
+)
+
+// Entry writer which keeps the written entries in memory
+type testEntryWriter struct {
+	names   []string
+	entries []string
+}
+
+func (w *testEntryWriter) write(b []byte) error {
+	return w.writeEntry(entryName(downloadFilenamePrfx, len(w.names)), b)
+}
+
+func (w *testEntryWriter) writeEntry(name string, b []byte) error {
+	w.names = append(w.names, name)
+	w.entries = append(w.entries, string(b))
+	return nil
+}
+
+func (w *testEntryWriter) close() {}
+
+func Test_sourceEntryName(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{fields: "pod=p1,ns=kube-system,cname=c1,cid=123", want: "kube-system/p1/c1.log"},
+		{fields: "pod=p1,cname=c1", want: "unknown/p1/c1.log"},
+		{fields: "pod=../p1,ns=..,cname=c/1", want: "unknown/.._p1/c_1.log"},
+		{fields: "file=gravity-system.log", want: "gravity-system.log"},
+		{fields: "", want: downloadFilenamePrfx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fields, func(t *testing.T) {
+			if got := sourceEntryName(&api.LogEvent{Fields: tt.fields}); got != tt.want {
+				t.Errorf("sourceEntryName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sourceLayout(t *testing.T) {
+	w := &testEntryWriter{}
+	l, err := newEntryLayout(layoutSource, w)
+	if err != nil {
+		t.Fatalf("newEntryLayout() error = %v", err)
+	}
+
+	big := strings.Repeat("x", downloadBytesPerFileLimit)
+	evs := []*api.LogEvent{
+		{Message: "m1", Fields: "pod=p1,ns=ns1,cname=c1"},
+		{Message: "m2", Fields: "pod=p2,ns=ns1,cname=c1"},
+		{Message: big, Fields: "pod=p1,ns=ns1,cname=c1"},
+		{Message: "m3", Fields: "pod=p1,ns=ns1,cname=c1"},
+	}
+	if err := l.add(evs); err != nil {
+		t.Fatalf("sourceLayout.add() error = %v", err)
+	}
+	if err := l.flush(); err != nil {
+		t.Fatalf("sourceLayout.flush() error = %v", err)
+	}
+
+	wantNames := []string{"ns1/p1/c1.log", "ns1/p1/c1.log.0", "ns1/p2/c1.log"}
+	if !reflect.DeepEqual(w.names, wantNames) {
+		t.Fatalf("sourceLayout entries = %v, want %v", w.names, wantNames)
+	}
+	if !strings.Contains(w.entries[0], "\"msg\":\"m1\"") || !strings.Contains(w.entries[0], big) {
+		t.Errorf("sourceLayout entry %v lacks expected events", w.names[0])
+	}
+	if !strings.Contains(w.entries[1], "\"msg\":\"m3\"") || !strings.Contains(w.entries[2], "\"msg\":\"m2\"") {
+		t.Errorf("sourceLayout entries = %v, want m3 and m2 events", w.entries[1:])
+	}
+}
+
+func Test_newEntryLayout(t *testing.T) {
+	if _, err := newEntryLayout("tree", &testEntryWriter{}); err == nil {
+		t.Errorf("newEntryLayout() expected error for unknown layout")
+	}
+	if l, err := newEntryLayout("", &testEntryWriter{}); err != nil || reflect.TypeOf(l) != reflect.TypeOf(&flatLayout{}) {
+		t.Errorf("newEntryLayout() = %T, %v, want flat layout", l, err)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Log response format, describes the "/v1/log" response
+	// and writes the log events in the particular format
+	logFormat struct {
+		// Response content type
+		contentType string
+		// Writes the given events to the buffer, marks are optional,
+		// if given they must be aligned with the events
+		write func(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error
+	}
+)
+
+const (
+	// Timestamp format of the text log formats
+	logTimestampFormat = "2006-01-02T15:04:05.999999Z07:00"
+)
+
+var (
+	// Default log response format, JSON array of the JSON-encoded
+	// Gravity log entries, it's what Gravity expects
+	defaultLogFormat = &logFormat{contentType: "application/json", write: writeGravityLogEntriesArray}
+
+	// Maps 'Accept' header media types to the log response formats
+	acceptToLogFormat = map[string]*logFormat{
+		"application/json":     defaultLogFormat,
+		"application/x-ndjson": {contentType: "application/x-ndjson", write: writeGravityLogEntries},
+		"text/plain":           {contentType: "text/plain; charset=utf-8", write: writeTextEvents},
+		"text/csv":             {contentType: "text/csv; charset=utf-8", write: writeCSVEvents},
+	}
+)
+
+// Returns log response format requested by 'Accept' header,
+// the first known media type wins, if the header doesn't contain
+// known types, the default format is returned.
+func getLogFormat(rq *http.Request) *logFormat {
+	for _, accept := range strings.Split(rq.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		if f, ok := acceptToLogFormat[mediaType]; ok {
+			return f
+		}
+	}
+	return defaultLogFormat
+}
+
+// Writes the given events as JSON array of JSON-encoded Gravity log entries
+func writeGravityLogEntriesArray(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	entries, err := toGravityLogEntries(evs, marks)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	buf.Write(b)
+	return nil
+}
+
+// Writes the given events as plain text lines "<timestamp> <message>",
+// if marks are given the lines are "<timestamp>: <message>" for the matches
+// and "<timestamp>- <message>" for the context (like grep does)
+func writeTextEvents(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	for i, e := range evs {
+		buf.WriteString(formatLogTimestamp(e.Timestamp))
+		if marks != nil {
+			buf.WriteByte(marks[i].separator())
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(strings.TrimRight(e.Message, "\r\n"))
+		buf.WriteByte('\n')
+	}
+	return nil
+}
+
+// Writes the given events as CSV with the header "ts,tags,fields,msg",
+// if marks are given the "kind" column is added
+func writeCSVEvents(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	w := csv.NewWriter(buf)
+	header := []string{"ts", "tags", "fields", "msg"}
+	if marks != nil {
+		header = append(header, "kind")
+	}
+	_ = w.Write(header)
+	for i, e := range evs {
+		rec := []string{formatLogTimestamp(e.Timestamp), e.Tags, e.Fields,
+			strings.TrimRight(e.Message, "\r\n")}
+		if marks != nil {
+			rec = append(rec, marks[i].String())
+		}
+		_ = w.Write(rec)
+	}
+	w.Flush()
+	return trace.Wrap(w.Error())
+}
+
+// Formats the given event timestamp (in nanoseconds) as UTC time
+func formatLogTimestamp(ts int64) string {
+	return time.Unix(0, ts).In(time.UTC).Format(logTimestampFormat)
+}
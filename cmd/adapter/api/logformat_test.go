@@ -0,0 +1,139 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/logrange/logrange/api"
+)
+
+func Test_getLogFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "no accept", want: "application/json"},
+		{name: "any", accept: "*/*", want: "application/json"},
+		{name: "ndjson", accept: "application/x-ndjson", want: "application/x-ndjson"},
+		{name: "plain text", accept: "text/plain;q=0.9", want: "text/plain; charset=utf-8"},
+		{name: "first known", accept: "text/html, text/csv, text/plain", want: "text/csv; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/v1/log", nil)
+			rq.Header.Set("Accept", tt.accept)
+			if got := getLogFormat(rq); got.contentType != tt.want {
+				t.Errorf("getLogFormat() = %v, want %v", got.contentType, tt.want)
+			}
+		})
+	}
+}
+
+func Test_logFormat_write(t *testing.T) {
+	evs := []*api.LogEvent{
+		{Timestamp: time.Date(2019, time.January, 1, 1, 1, 1, 0, time.UTC).UnixNano(),
+			Message: "hello\n", Fields: "pod=p1,cname=c1"},
+		{Timestamp: time.Date(2019, time.January, 1, 1, 1, 2, 500000000, time.UTC).UnixNano(),
+			Message: "say \"hi\", bye"},
+	}
+	tests := []struct {
+		name   string
+		format *logFormat
+		want   string
+	}{
+		{
+			name:   "default",
+			format: defaultLogFormat,
+			want: `["{\"type\":\"data\",\"payload\":\"hello\\n\",\"tags\":{\"\":\"\"},\"fields\":{\"cname\":\"c1\",\"pod\":\"p1\"}}",` +
+				`"{\"type\":\"data\",\"payload\":\"say \\\"hi\\\", bye\",\"tags\":{\"\":\"\"},\"fields\":{\"\":\"\"}}"]`,
+		},
+		{
+			name:   "ndjson",
+			format: acceptToLogFormat["application/x-ndjson"],
+			want: `{"type":"data","payload":"hello\n","tags":{"":""},"fields":{"cname":"c1","pod":"p1"}}` + "\n" +
+				`{"type":"data","payload":"say \"hi\", bye","tags":{"":""},"fields":{"":""}}` + "\n",
+		},
+		{
+			name:   "text",
+			format: acceptToLogFormat["text/plain"],
+			want:   "2019-01-01T01:01:01Z hello\n2019-01-01T01:01:02.5Z say \"hi\", bye\n",
+		},
+		{
+			name:   "csv",
+			format: acceptToLogFormat["text/csv"],
+			want: "ts,tags,fields,msg\n2019-01-01T01:01:01Z,,\"pod=p1,cname=c1\",hello\n" +
+				"2019-01-01T01:01:02.5Z,,,\"say \"\"hi\"\", bye\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.format.write(evs, nil, &buf); err != nil {
+				t.Fatalf("logFormat.write() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("logFormat.write() = %v, want %v", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_logFormat_write_marks(t *testing.T) {
+	evs := []*api.LogEvent{
+		{Timestamp: time.Date(2019, time.January, 1, 1, 1, 1, 0, time.UTC).UnixNano(), Message: "before"},
+		{Timestamp: time.Date(2019, time.January, 1, 1, 1, 2, 0, time.UTC).UnixNano(), Message: "error"},
+	}
+	marks := []eventMark{markContext, markMatch}
+	tests := []struct {
+		name   string
+		format *logFormat
+		want   string
+	}{
+		{
+			name:   "ndjson",
+			format: acceptToLogFormat["application/x-ndjson"],
+			want: `{"type":"data","payload":"before","tags":{"":""},"fields":{"":""},"kind":"context"}` + "\n" +
+				`{"type":"data","payload":"error","tags":{"":""},"fields":{"":""},"kind":"match"}` + "\n",
+		},
+		{
+			name:   "text",
+			format: acceptToLogFormat["text/plain"],
+			want:   "2019-01-01T01:01:01Z- before\n2019-01-01T01:01:02Z: error\n",
+		},
+		{
+			name:   "csv",
+			format: acceptToLogFormat["text/csv"],
+			want:   "ts,tags,fields,msg,kind\n2019-01-01T01:01:01Z,,,before,context\n2019-01-01T01:01:02Z,,,error,match\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.format.write(evs, marks, &buf); err != nil {
+				t.Fatalf("logFormat.write() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("logFormat.write() = %v, want %v", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Represents log entry of "/v2/log" response
+	logEntryV2 struct {
+		// Entry schema version, it's always logEntryVersion
+		Version int `json:"version"`
+		// RFC3339 timestamp (UTC)
+		Timestamp string `json:"timestamp"`
+		// Detected severity, see detectSeverity()
+		Severity string            `json:"severity"`
+		Message  string            `json:"message"`
+		Tags     map[string]string `json:"tags"`
+		Fields   map[string]string `json:"fields"`
+		// Either "match" or "context", set only if context lines are requested
+		Kind string `json:"kind,omitempty"`
+	}
+)
+
+const (
+	// Version of "/v2/log" entry schema
+	logEntryVersion = 2
+
+	// Log entry severities
+	severityFatal   = "fatal"
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+	severityDebug   = "debug"
+	severityUnknown = "unknown"
+
+	// Number of the message leading bytes where the severity is looked for
+	severityScanBytes = 256
+
+	// Alternation of the severity names (see severityNames)
+	severityNamesRe = `fatal|panic|crit|critical|error|err|warning|warn|info|notice|debug|trace`
+)
+
+var (
+	// "/v2/log" response formats per 'Accept' header media type,
+	// JSON array of the entries is the default one
+	logV2Formats = map[string]*logFormat{
+		"application/json":     {contentType: "application/json", write: writeLogEntriesV2},
+		"application/x-ndjson": {contentType: "application/x-ndjson", write: writeLogEntriesV2NDJSON},
+	}
+
+	// Maps the severity names (lower-cased) to the severities
+	severityNames = map[string]string{
+		"fatal": severityFatal, "panic": severityFatal, "crit": severityFatal, "critical": severityFatal,
+		"error": severityError, "err": severityError,
+		"warning": severityWarning, "warn": severityWarning,
+		"info": severityInfo, "notice": severityInfo,
+		"debug": severityDebug, "trace": severityDebug,
+	}
+
+	// Maps klog (glog) line prefixes to the severities
+	klogSeverities = map[byte]string{
+		'F': severityFatal, 'E': severityError, 'W': severityWarning, 'I': severityInfo,
+	}
+
+	// Matches klog (glog) line header, e.g. "E0101 01:01:01.000000 ..."
+	klogHeaderRe = regexp.MustCompile(`^[FEWI]\d{4} \d{2}:\d{2}:\d{2}`)
+
+	// Matches the severity name marked as such, e.g. "level=error", "[WARN]",
+	// "\"level\":\"info\"", the name is the 1st or the 2nd submatch
+	severityMarkerRe = regexp.MustCompile(`(?i)\b(?:level|lvl|severity)"?\s*[=:]\s*"?(` + severityNamesRe +
+		`)\b|\[(` + severityNamesRe + `)\]`)
+
+	// Matches the severity name as a separate word
+	severityNameRe = regexp.MustCompile(`(?i)\b(` + severityNamesRe + `)\b`)
+
+	// Severities ordered from the most to the least severe one
+	severityOrder = []string{severityFatal, severityError, severityWarning, severityInfo, severityDebug}
+
+	// Fields which could contain the severity
+	severityFields = []string{"level", "severity"}
+)
+
+// "/v2/log" api handler, accepts the same params as "/v1/log"
+// (except 'follow') and returns JSON array of the log entries
+// of the versioned schema (see logEntryV2) or NDJSON, if
+// "application/x-ndjson" is accepted
+func (s *Server) logV2Handler(ctx context.Context, rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	if follow, _ := strconv.ParseBool(rq.URL.Query().Get("follow")); follow {
+		return trace.BadParameter("follow is not supported by /v2/log")
+	}
+	return s.serveLog(ctx, rw, rq, getLogV2Format(rq))
+}
+
+// Returns "/v2/log" response format requested by 'Accept' header,
+// the first known media type wins, JSON is the default one
+func getLogV2Format(rq *http.Request) *logFormat {
+	for _, accept := range strings.Split(rq.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		if f, ok := logV2Formats[mediaType]; ok {
+			return f
+		}
+	}
+	return logV2Formats["application/json"]
+}
+
+// Writes the given events as JSON array of the log entries
+func writeLogEntriesV2(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	b, err := json.Marshal(toLogEntriesV2(evs, marks))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	buf.Write(b)
+	return nil
+}
+
+// Writes the given events as NDJSON (one log entry per line)
+func writeLogEntriesV2NDJSON(evs []*api.LogEvent, marks []eventMark, buf *bytes.Buffer) error {
+	enc := json.NewEncoder(buf)
+	for _, e := range toLogEntriesV2(evs, marks) {
+		if err := enc.Encode(e); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func toLogEntriesV2(evs []*api.LogEvent, marks []eventMark) []*logEntryV2 {
+	entries := make([]*logEntryV2, 0, len(evs))
+	for i, e := range evs {
+		entry := &logEntryV2{
+			Version:   logEntryVersion,
+			Timestamp: time.Unix(0, e.Timestamp).In(time.UTC).Format(time.RFC3339Nano),
+			Message:   e.Message,
+			Tags:      parseCSVIntoMap(e.Tags),
+			Fields:    parseCSVIntoMap(e.Fields),
+		}
+		entry.Severity = detectSeverity(e.Message, entry.Fields)
+		if marks != nil {
+			entry.Kind = marks[i].String()
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Detects log entry severity by the given fields ("level" or "severity")
+// or message, which is either klog (glog) formatted (e.g. "E0101 01:01:01.000000 ...")
+// or contains the severity name near the beginning. The marked names (e.g. "level=error",
+// "[WARN]") are checked first, otherwise the most severe bare name is taken.
+// The severities are: "fatal", "error", "warning", "info", "debug" or "unknown".
+func detectSeverity(msg string, fields map[string]string) string {
+	for _, f := range severityFields {
+		if sev, ok := severityNames[strings.ToLower(fields[f])]; ok {
+			return sev
+		}
+	}
+
+	if len(msg) > severityScanBytes {
+		msg = msg[:severityScanBytes]
+	}
+	if klogHeaderRe.MatchString(msg) {
+		return klogSeverities[msg[0]]
+	}
+	if m := severityMarkerRe.FindStringSubmatch(msg); m != nil {
+		return severityNames[strings.ToLower(m[1]+m[2])]
+	}
+
+	// no marker, the most severe bare word wins, e.g. "failed to get info: error" is error
+	found := make(map[string]bool)
+	for _, m := range severityNameRe.FindAllString(msg, -1) {
+		found[severityNames[strings.ToLower(m)]] = true
+	}
+	for _, sev := range severityOrder {
+		if found[sev] {
+			return sev
+		}
+	}
+	return severityUnknown
+}
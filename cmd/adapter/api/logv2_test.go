@@ -0,0 +1,107 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/logrange/logrange/api"
+)
+
+func Test_detectSeverity(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    string
+		fields map[string]string
+		want   string
+	}{
+		{name: "level field", msg: "error happened", fields: map[string]string{"level": "WARN"}, want: severityWarning},
+		{name: "klog", msg: "E0101 01:01:01.000000       1 controller.go:1] info", want: severityError},
+		{name: "logfmt", msg: `time="2019-01-01T01:01:01Z" level=info msg="error is ok"`, want: severityInfo},
+		{name: "json", msg: `{"level":"debug","msg":"hi"}`, want: severityDebug},
+		{name: "brackets", msg: "2019/01/01 01:01:01 [FATAL] bye", want: severityFatal},
+		{name: "logfmt before bare words", msg: `error count=0 level=warn`, want: severityWarning},
+		{name: "json before bare words", msg: `{"msg":"error","level":"debug"}`, want: severityDebug},
+		{name: "brackets before bare words", msg: "error count 0 [INFO] done", want: severityInfo},
+		{name: "most severe bare word", msg: "failed to get info: error", want: severityError},
+		{name: "not a word", msg: "errors=0 warnings=0", want: severityUnknown},
+		{name: "beyond scan limit", msg: string(make([]byte, severityScanBytes)) + " error", want: severityUnknown},
+		{name: "unknown", msg: "hello", want: severityUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectSeverity(tt.msg, tt.fields); got != tt.want {
+				t.Errorf("detectSeverity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_logV2Handler(t *testing.T) {
+	newClient := func() *testLrClient {
+		return &testLrClient{
+			results: []*api.QueryResult{{Events: []*api.LogEvent{{
+				Timestamp: time.Date(2019, time.January, 1, 1, 1, 1, 500, time.UTC).UnixNano(),
+				Message:   "W0101 01:01:01.000000 1 a.go:1] slow",
+				Fields:    "pod=p1",
+				Tags:      "t=1",
+			}}}},
+		}
+	}
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{
+			name: "json",
+			want: `[{"version":2,"timestamp":"2019-01-01T01:01:01.0000005Z","severity":"warning",` +
+				`"message":"W0101 01:01:01.000000 1 a.go:1] slow","tags":{"t":"1"},"fields":{"pod":"p1"}}]`,
+		},
+		{
+			name:   "ndjson",
+			accept: "application/x-ndjson",
+			want: `{"version":2,"timestamp":"2019-01-01T01:01:01.0000005Z","severity":"warning",` +
+				`"message":"W0101 01:01:01.000000 1 a.go:1] slow","tags":{"t":"1"},"fields":{"pod":"p1"}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{lrClient: newClient(), lrPartition: "partition", logger: log.WithField("test", "log")}
+			rq := httptest.NewRequest("GET", "/v2/log?query=pod:p1", nil)
+			rq.Header.Set("Accept", tt.accept)
+			rw := httptest.NewRecorder()
+			if err := s.logV2Handler(context.Background(), rw, rq, nil); err != nil {
+				t.Fatalf("Server.logV2Handler() error = %v", err)
+			}
+			if rw.Body.String() != tt.want {
+				t.Errorf("Server.logV2Handler() = %v, want %v", rw.Body.String(), tt.want)
+			}
+		})
+	}
+
+	s := &Server{logger: log.WithField("test", "log")}
+	err := s.logV2Handler(context.Background(), httptest.NewRecorder(),
+		httptest.NewRequest("GET", "/v2/log?follow=true", nil), nil)
+	if err == nil {
+		t.Errorf("Server.logV2Handler(follow) error = nil, want bad parameter")
+	}
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LK4D4/joincontext"
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+	"golang.org/x/net/websocket"
+)
+
+type (
+	// Represents Loki API response
+	lokiResponse struct {
+		// Always "success", the errors are returned as the other api ones
+		Status string      `json:"status"`
+		Data   interface{} `json:"data"`
+	}
+
+	// Represents "/loki/api/v1/query_range" response data
+	lokiQueryData struct {
+		// Always "streams", the metric queries are not supported
+		ResultType string                 `json:"resultType"`
+		Result     []*lokiStream          `json:"result"`
+		Stats      map[string]interface{} `json:"stats"`
+	}
+
+	// Represents "/loki/api/v1/tail" response message
+	lokiTailMessage struct {
+		Streams []*lokiStream `json:"streams"`
+	}
+
+	// Log entries of the same labels (Logrange fields)
+	lokiStream struct {
+		Stream map[string]string `json:"stream"`
+		// Pairs of the entry timestamp (unix epoch in nanoseconds) and message
+		Values [][2]string `json:"values"`
+	}
+)
+
+const (
+	// Loki query default limit
+	defaultLokiLinesLimit = 100
+
+	// Loki query maximum limit
+	lokiLinesLimitMax = 5000
+
+	// Loki query default time range (back from the end)
+	defaultLokiQueryRange = time.Hour
+
+	// Maximum number of digits of the integer time param in seconds,
+	// the longer integers are in nanoseconds
+	lokiSecondsDigitsMax = 10
+)
+
+// "/loki/api/v1/query_range" api handler, returns the log entries grouped by streams
+// for the given LogQL log query (see query.BuildLqlQueryFromLogQL()), params:
+//
+// - 'query':
+//      LogQL stream selector followed by line filters, the labels are the Logrange fields
+//      example: query={pod=~"nginx-.*",cname="nginx"} |= "error" != "timeout"
+// - 'start', 'end':
+//      allowed values: unix epoch in seconds (integer of up to 10 digits or fractional)
+//      or nanoseconds (longer integer), or RFC3339 timestamp,
+//      the default ones are one hour ago and now
+//      example: start=1546300800000000000&end=1546304400
+// - 'limit':
+//      allowed values: int in [1, 5000], 100 by default
+//      example: limit=1000
+// - 'direction':
+//      allowed values: "backward" (the latest entries, default) or "forward" (the earliest entries)
+//      example: direction=forward
+//
+// The response is Loki "streams" result, so that Grafana Loki datasource can use it.
+func (s *Server) lokiQueryRangeHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	now := time.Now()
+	tr, err := getLokiTimeRangeParams(rq, now)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	limit, err := getLokiLimitParam(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	pos, offset := "tail", -limit
+	switch d := rq.URL.Query().Get("direction"); d {
+	case "", "backward":
+	case "forward":
+		pos, offset = "head", 0
+	default:
+		return trace.BadParameter("invalid direction: %q is neither backward nor forward", d)
+	}
+
+	lql, err := query.BuildLqlQueryFromLogQL(rq.URL.Query().Get("query"), s.lrPartition, tr, limit, offset)
+	if err != nil {
+		return trace.BadParameter(err.Error())
+	}
+	qr := &api.QueryRequest{Query: lql, Pos: pos, Offset: offset, Limit: limit}
+	s.logger.Info("lokiQueryRange(): Query=", qr.Query, ", Pos=", qr.Pos)
+
+	// join contexts to handle both server interruption (SIGINT) and transport err
+	jctx, cancel := joincontext.Join(ctx, rq.Context())
+	defer cancel()
+
+	res := &api.QueryResult{}
+	if err = s.lrClient.Query(jctx, qr, res); err != nil {
+		return trace.Wrap(err)
+	}
+	if res.Err != nil {
+		return trace.Wrap(res.Err)
+	}
+
+	evs := res.Events
+	if pos == "tail" { // the latest entries go first
+		evs = make([]*api.LogEvent, 0, len(res.Events))
+		for i := len(res.Events) - 1; i >= 0; i-- {
+			evs = append(evs, res.Events[i])
+		}
+	}
+	return writeJson(rw, lokiResponse{Status: "success", Data: lokiQueryData{
+		ResultType: "streams",
+		Result:     toLokiStreams(evs),
+		Stats:      map[string]interface{}{},
+	}})
+}
+
+// "/loki/api/v1/labels" api handler, returns the field names
+// seen in the recent events (see "/v1/fields"), sorted by name
+func (s *Server) lokiLabelsHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	values, err := s.getFieldValues(ctx, rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return writeJson(rw, lokiResponse{Status: "success", Data: names})
+}
+
+// "/loki/api/v1/label/{name}/values" api handler, returns the distinct values
+// of the field seen in the recent events (see "/v1/fields/{name}/values"), sorted
+func (s *Server) lokiLabelValuesHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	values, err := s.getFieldValues(ctx, rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	vals := make([]string, 0, len(values[p.ByName("name")]))
+	for v := range values[p.ByName("name")] {
+		vals = append(vals, v)
+	}
+	sort.Strings(vals)
+	return writeJson(rw, lokiResponse{Status: "success", Data: vals})
+}
+
+// "/loki/api/v1/tail" api handler, it's WebSocket endpoint, which pushes
+// the log entries as they arrive, params:
+//
+// - 'query': see "/loki/api/v1/query_range"
+// - 'start':
+//      the entries before it are not pushed, one hour ago by default
+//      allowed values: see "/loki/api/v1/query_range"
+// - 'limit':
+//      number of the already stored entries to push before following new ones
+//      allowed values: int in [1, 5000], 100 by default
+//
+// Every message is Loki tail response, i.e. JSON object with the streams of the new entries.
+// The stream ends when either the client goes away or the server is being stopped.
+func (s *Server) lokiTailHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	// x/net/websocket panics if the connection can't be hijacked (e.g. HTTP/2)
+	if !isWebSocketUpgrade(rq) {
+		return trace.BadParameter("invalid request: WebSocket upgrade over HTTP/1.x is expected")
+	}
+	start, err := parseLokiTimeParam(rq.URL.Query().Get("start"), time.Now().Add(-defaultLokiQueryRange))
+	if err != nil {
+		return trace.BadParameter("invalid start: %v", err)
+	}
+	limit, err := getLokiLimitParam(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	// build Logrange query, it starts 'limit' entries before the tail
+	// and then waits for new entries at most streamWaitTimeoutSec per query
+	lql, err := query.BuildLqlQueryFromLogQL(rq.URL.Query().Get("query"), s.lrPartition,
+		query.TimeRange{Since: start}, streamBatchLinesLimit, -limit)
+	if err != nil {
+		return trace.BadParameter(err.Error())
+	}
+	qr := &api.QueryRequest{Query: lql, Pos: "tail", Offset: -limit, Limit: streamBatchLinesLimit,
+		WaitTimeout: streamWaitTimeoutSec}
+	s.logger.Info("lokiTail(): Query=", qr.Query)
+
+	// the browsers send cookies and client certificates to any origin,
+	// so the foreign origins are rejected to prevent cross-site WebSocket hijacking
+	ws := websocket.Server{Handshake: s.checkLokiTailOrigin, Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		// join contexts to handle both server interruption (SIGINT) and transport err
+		jctx, cancel := joincontext.Join(ctx, rq.Context())
+		defer cancel()
+
+		// the client doesn't send anything, but it closes the connection when it goes away
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			cancel()
+		}()
+
+		err := api.Select(jctx, s.lrClient, qr, true,
+			func(res *api.QueryResult) {
+				if len(res.Events) == 0 {
+					return
+				}
+				if errW := websocket.JSON.Send(conn, lokiTailMessage{Streams: toLokiStreams(res.Events)}); errW != nil {
+					s.logger.Warn("lokiTail(): Response write err=", errW)
+					cancel()
+				}
+			})
+		// the stream is stopped by either client or server, it's not an error
+		if err != nil && jctx.Err() == nil {
+			s.logger.Error("lokiTail(): Query err=", err)
+		}
+	}}
+	ws.ServeHTTP(rw, rq)
+	return nil
+}
+
+// Returns true if the given request is WebSocket upgrade request over HTTP/1.x
+func isWebSocketUpgrade(rq *http.Request) bool {
+	if rq.ProtoMajor != 1 || !strings.EqualFold(rq.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range rq.Header["Connection"] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Loki tail WebSocket handshake, accepts the requests without Origin (non-browser clients),
+// from the API host itself or from the allowed origins (see SetLokiTailOrigins())
+func (s *Server) checkLokiTailOrigin(cfg *websocket.Config, rq *http.Request) error {
+	origin, err := websocket.Origin(cfg, rq)
+	if err != nil {
+		return trace.BadParameter("invalid Origin: %v", err)
+	}
+	cfg.Origin = origin
+	if origin == nil || strings.EqualFold(origin.Host, rq.Host) {
+		return nil
+	}
+	for _, o := range s.lokiTailOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+	return trace.AccessDenied("origin %v is not allowed", origin)
+}
+
+// SetLokiTailOrigins sets the origins (e.g. "https://grafana.example.com"), which are allowed
+// to open "/loki/api/v1/tail" WebSocket besides the API host itself, it must be called before Serve()
+func (s *Server) SetLokiTailOrigins(origins []string) {
+	s.lokiTailOrigins = origins
+}
+
+// Groups the given events by their fields into Loki streams, the events order is kept
+func toLokiStreams(evs []*api.LogEvent) []*lokiStream {
+	res := []*lokiStream{}
+	streams := make(map[string]*lokiStream)
+	for _, e := range evs {
+		st, ok := streams[e.Fields]
+		if !ok {
+			st = &lokiStream{Stream: parseCSVIntoMap(e.Fields), Values: [][2]string{}}
+			delete(st.Stream, "")
+			streams[e.Fields] = st
+			res = append(res, st)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(e.Timestamp, 10), e.Message})
+	}
+	return res
+}
+
+// Returns 'start' and 'end' request params as time range, by default
+// it's the one hour (defaultLokiQueryRange) before the given time
+func getLokiTimeRangeParams(rq *http.Request, now time.Time) (query.TimeRange, error) {
+	var tr query.TimeRange
+	var err error
+	if tr.Until, err = parseLokiTimeParam(rq.URL.Query().Get("end"), now); err != nil {
+		return tr, trace.BadParameter("invalid end: %v", err)
+	}
+	if tr.Since, err = parseLokiTimeParam(rq.URL.Query().Get("start"), tr.Until.Add(-defaultLokiQueryRange)); err != nil {
+		return tr, trace.BadParameter("invalid start: %v", err)
+	}
+	if tr.Since.After(tr.Until) {
+		return tr, trace.BadParameter("invalid start=%v: must not be after end=%v", tr.Since, tr.Until)
+	}
+	return tr, nil
+}
+
+// Parses Loki time param, which is either unix epoch in seconds (integer of up to 10 digits
+// or fractional) or nanoseconds (longer integer), or RFC3339 timestamp, empty value
+// results in the given default one. The time must be in the range of the other time params.
+func parseLokiTimeParam(v string, def time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return def, nil
+	}
+	var t time.Time
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		// the same way as Loki does
+		if len(strings.TrimLeft(v, "+-")) <= lokiSecondsDigitsMax {
+			t = time.Unix(n, 0)
+		} else {
+			t = time.Unix(0, n)
+		}
+	} else if sec, err := strconv.ParseFloat(v, 64); err == nil {
+		if sec < float64(query.MinTime.Unix()) || sec > float64(query.MaxTime.Unix()) {
+			return time.Time{}, trace.BadParameter("%v is out of range", v)
+		}
+		whole, frac := math.Modf(sec)
+		t = time.Unix(int64(whole), int64(frac*float64(time.Second)))
+	} else if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+		return time.Time{}, trace.BadParameter("%q is neither unix epoch nor RFC3339 timestamp", v)
+	}
+	if t.Before(query.MinTime) || t.After(query.MaxTime) {
+		return time.Time{}, trace.BadParameter("%v is out of range", v)
+	}
+	return t, nil
+}
+
+// Returns 'limit' request param of Loki query, it must be in [1, lokiLinesLimitMax]
+func getLokiLimitParam(rq *http.Request) (int, error) {
+	v := strings.TrimSpace(rq.URL.Query().Get("limit"))
+	if v == "" {
+		return defaultLokiLinesLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > lokiLinesLimitMax {
+		return 0, trace.BadParameter("invalid limit: %q is not integer in [1, %d]", v, lokiLinesLimitMax)
+	}
+	return limit, nil
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+	"golang.org/x/net/websocket"
+)
+
+func TestServer_lokiQueryRangeHandler(t *testing.T) {
+	evs := []*api.LogEvent{
+		{Timestamp: 1, Message: "m1", Fields: "pod=p1,cname=c1"},
+		{Timestamp: 2, Message: "m2", Fields: "pod=p2"},
+		{Timestamp: 3, Message: "m3", Fields: "pod=p1,cname=c1"},
+	}
+	tests := []struct {
+		name      string
+		params    string
+		wantQuery api.QueryRequest
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:   "backward",
+			params: "query={pod=~\"p.*\"}+|%3D+\"m\"&start=100&end=200&limit=3",
+			wantQuery: api.QueryRequest{Pos: "tail", Offset: -3, Limit: 3,
+				Query: "SELECT FROM partition WHERE (fields:pod PREFIX \"p\" AND msg CONTAINS \"m\") AND " +
+					"ts >= \"100000000000\" AND ts <= \"200000000000\" OFFSET -3 LIMIT 3"},
+			want: `{"status":"success","data":{"resultType":"streams","result":[` +
+				`{"stream":{"cname":"c1","pod":"p1"},"values":[["3","m3"],["1","m1"]]},` +
+				`{"stream":{"pod":"p2"},"values":[["2","m2"]]}],"stats":{}}}`,
+		},
+		{
+			name:   "forward",
+			params: "query={}&start=1.5&end=2019-01-01T00:00:00Z&direction=forward",
+			wantQuery: api.QueryRequest{Pos: "head", Limit: 100,
+				Query: "SELECT FROM partition WHERE ts >= \"1500000000\" AND ts <= \"1546300800000000000\" LIMIT 100"},
+			want: `{"status":"success","data":{"resultType":"streams","result":[` +
+				`{"stream":{"cname":"c1","pod":"p1"},"values":[["1","m1"],["3","m3"]]},` +
+				`{"stream":{"pod":"p2"},"values":[["2","m2"]]}],"stats":{}}}`,
+		},
+		{name: "metric query", params: "query=count_over_time({pod=\"p1\"}[5m])", wantErr: true},
+		{name: "bad direction", params: "query={}&direction=up", wantErr: true},
+		{name: "bad limit", params: "query={}&limit=0", wantErr: true},
+		{name: "bad range", params: "query={}&start=2&end=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &testLrClient{results: []*api.QueryResult{{Events: evs}}}
+			s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "loki")}
+
+			rw := httptest.NewRecorder()
+			err := s.lokiQueryRangeHandler(context.Background(), rw,
+				httptest.NewRequest("GET", "/loki/api/v1/query_range?"+tt.params, nil), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Server.lokiQueryRangeHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(cli.reqs) != 1 || !reflect.DeepEqual(cli.reqs[0], tt.wantQuery) {
+				t.Errorf("Server.lokiQueryRangeHandler() queries = %v, want %v", cli.reqs, &tt.wantQuery)
+			}
+			if got := rw.Body.String(); got != tt.want {
+				t.Errorf("Server.lokiQueryRangeHandler() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_lokiLabelsHandlers(t *testing.T) {
+	s := &Server{logger: log.WithField("test", "loki")}
+	// the cached values, no queries are expected
+	s.fields.values = map[string]map[string]struct{}{
+		"pod":   {"nginx-2": {}, "nginx-1": {}},
+		"cname": {"nginx": {}},
+	}
+	s.fields.expiresAt = time.Now().Add(time.Minute)
+
+	rw := httptest.NewRecorder()
+	if err := s.lokiLabelsHandler(context.Background(), rw,
+		httptest.NewRequest("GET", "/loki/api/v1/labels", nil), nil); err != nil {
+		t.Fatalf("Server.lokiLabelsHandler() error = %v", err)
+	}
+	if got, want := rw.Body.String(), `{"status":"success","data":["cname","pod"]}`; got != want {
+		t.Errorf("Server.lokiLabelsHandler() = %v, want %v", got, want)
+	}
+
+	for name, want := range map[string]string{
+		"pod":       `{"status":"success","data":["nginx-1","nginx-2"]}`,
+		"container": `{"status":"success","data":[]}`,
+	} {
+		rw = httptest.NewRecorder()
+		if err := s.lokiLabelValuesHandler(context.Background(), rw,
+			httptest.NewRequest("GET", "/loki/api/v1/label/"+name+"/values", nil),
+			httprouter.Params{{Key: "name", Value: name}}); err != nil {
+			t.Fatalf("Server.lokiLabelValuesHandler() error = %v", err)
+		}
+		if got := rw.Body.String(); got != want {
+			t.Errorf("Server.lokiLabelValuesHandler(%v) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestServer_lokiTailHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{
+				Events:           []*api.LogEvent{{Timestamp: 1, Message: "m1", Fields: "pod=p1"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos1", WaitTimeout: streamWaitTimeoutSec},
+			},
+			{NextQueryRequest: api.QueryRequest{Pos: "pos1", WaitTimeout: streamWaitTimeoutSec}},
+			{
+				Events:           []*api.LogEvent{{Timestamp: 2, Message: "m2", Fields: "pod=p1"}},
+				NextQueryRequest: api.QueryRequest{Pos: "pos2", WaitTimeout: streamWaitTimeoutSec},
+			},
+		},
+		cancel: cancel,
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "loki")}
+	// the metrics are not observed, so that they don't affect the other tests
+	router := httprouter.New()
+	router.GET("/loki/api/v1/tail", s.makeHandlerWithCtx(ctx, s.lokiTailHandler))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// non-upgrade request and bad query are rejected before the upgrade
+	for _, tc := range []struct {
+		query   string
+		upgrade bool
+	}{{query: "{pod%3D\"p1\"}"}, {query: "pod:p1", upgrade: true}} {
+		rq, _ := http.NewRequest("GET", srv.URL+"/loki/api/v1/tail?query="+tc.query, nil)
+		if tc.upgrade {
+			rq.Header.Set("Connection", "Upgrade")
+			rq.Header.Set("Upgrade", "websocket")
+		}
+		resp, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatalf("Server.lokiTailHandler() error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Server.lokiTailHandler(%v) status = %v, want %v", tc, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// foreign origin is rejected before any query
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/loki/api/v1/tail?query={pod%3D\"p1\"}&start=10&limit=5"
+	if conn, err := websocket.Dial(wsURL, "", "https://evil.example.com"); err == nil {
+		conn.Close()
+		t.Fatalf("Server.lokiTailHandler() foreign origin error = nil, want forbidden")
+	}
+	if len(cli.reqs) != 0 {
+		t.Fatalf("Server.lokiTailHandler() queries = %v, want none before the upgrade", len(cli.reqs))
+	}
+
+	conn, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatalf("Server.lokiTailHandler() error = %v", err)
+	}
+	defer conn.Close()
+
+	var got []string
+	for {
+		var msg json.RawMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			break // the server closes the connection when the query is cancelled
+		}
+		got = append(got, string(msg))
+	}
+	want := []string{
+		`{"streams":[{"stream":{"pod":"p1"},"values":[["1","m1"]]}]}`,
+		`{"streams":[{"stream":{"pod":"p1"},"values":[["2","m2"]]}]}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Server.lokiTailHandler() messages = %v, want %v", got, want)
+	}
+
+	if len(cli.reqs) != 4 {
+		t.Fatalf("Server.lokiTailHandler() queries = %v, want 4", len(cli.reqs))
+	}
+	first := cli.reqs[0]
+	wantQuery := "SELECT FROM partition WHERE fields:pod=\"p1\" AND ts >= \"10000000000\" OFFSET -5 LIMIT 1000"
+	if first.Query != wantQuery || first.Pos != "tail" || first.Offset != -5 || first.WaitTimeout != streamWaitTimeoutSec {
+		t.Errorf("Server.lokiTailHandler() first query = %v, want %v", &first, wantQuery)
+	}
+}
+
+func Test_isWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		protoMajor int
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{name: "upgrade", protoMajor: 1, connection: "Upgrade", upgrade: "websocket", want: true},
+		{name: "connection list", protoMajor: 1, connection: "keep-alive, upgrade", upgrade: "WebSocket", want: true},
+		{name: "no upgrade", protoMajor: 1, connection: "keep-alive"},
+		{name: "other protocol", protoMajor: 1, connection: "Upgrade", upgrade: "h2c"},
+		{name: "HTTP/2", protoMajor: 2, connection: "Upgrade", upgrade: "websocket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/loki/api/v1/tail", nil)
+			rq.ProtoMajor = tt.protoMajor
+			rq.Header.Set("Connection", tt.connection)
+			rq.Header.Set("Upgrade", tt.upgrade)
+			if got := isWebSocketUpgrade(rq); got != tt.want {
+				t.Errorf("isWebSocketUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_checkLokiTailOrigin(t *testing.T) {
+	s := &Server{lokiTailOrigins: []string{"https://grafana.example.com/"}}
+	tests := []struct {
+		origin  string
+		wantErr bool
+	}{
+		{origin: ""},
+		{origin: "https://log-collector:8083"},
+		{origin: "https://grafana.example.com"},
+		{origin: "http://grafana.example.com", wantErr: true},
+		{origin: "https://evil.example.com", wantErr: true},
+		{origin: "null", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "https://log-collector:8083/loki/api/v1/tail", nil)
+			if tt.origin != "" {
+				rq.Header.Set("Origin", tt.origin)
+			}
+			if err := s.checkLokiTailOrigin(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, rq); (err != nil) != tt.wantErr {
+				t.Errorf("Server.checkLokiTailOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseLokiTimeParam(t *testing.T) {
+	def := time.Unix(5, 0)
+	tests := []struct {
+		v       string
+		want    time.Time
+		wantErr bool
+	}{
+		{v: "", want: def},
+		{v: "1546300800000000000", want: time.Unix(1546300800, 0)},
+		{v: "1546300800", want: time.Unix(1546300800, 0)},
+		{v: "0", want: time.Unix(0, 0)},
+		{v: "15463008000", want: time.Unix(15, 463008000)},
+		{v: "1546300800.5", want: time.Unix(1546300800, 500000000)},
+		{v: "2019-01-01T00:00:00.5Z", want: time.Unix(1546300800, 500000000)},
+		{v: "15m", wantErr: true},
+		{v: "9999999999", wantErr: true},
+		{v: "1e300", wantErr: true},
+		{v: "0001-01-01T00:00:00Z", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			got, err := parseLokiTimeParam(tt.v, def)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLokiTimeParam() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseLokiTimeParam() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/logrange/logrange/api"
+)
+
+// Logrange client stub, records the requests and either calls the given query
+// function or returns the given results one by one and cancels the context
+// when there are no more results
+type testLrClient struct {
+	api.Client
+	results []*api.QueryResult
+	query   func(res *api.QueryResult) error
+	cancel  context.CancelFunc
+
+	lock sync.Mutex
+	reqs []api.QueryRequest
+}
+
+func (c *testLrClient) Query(ctx context.Context, req *api.QueryRequest, res *api.QueryResult) error {
+	c.lock.Lock()
+	c.reqs = append(c.reqs, *req)
+	if c.query != nil {
+		c.lock.Unlock()
+		return c.query(res)
+	}
+	if len(c.results) == 0 {
+		c.lock.Unlock()
+		c.cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	*res = *c.results[0]
+	c.results = c.results[1:]
+	c.lock.Unlock()
+	return nil
+}
+
+// Returns the query function, which fails with the given transport
+// error or returns the result with the given server error
+func queryErr(err, resErr error) func(res *api.QueryResult) error {
+	return func(res *api.QueryResult) error {
+		res.Err = resErr
+		return err
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gravitational/logging-app/cmd/adapter/metrics"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Logrange client which counts failed queries
+	lrClientWithMetrics struct {
+		api.Client
+	}
+
+	// Http response writer which counts written bytes
+	responseWriterWithMetrics struct {
+		http.ResponseWriter
+		status int
+		bytes  int
+	}
+)
+
+func (c *lrClientWithMetrics) Query(ctx context.Context, req *api.QueryRequest, res *api.QueryResult) error {
+	err := c.Client.Query(ctx, req, res)
+	if (err != nil && ctx.Err() == nil) || (err == nil && res.Err != nil) {
+		metrics.QueryErrors.Inc()
+	}
+	return err
+}
+
+// Wrapper for http handler, which observes the request latency,
+// status code and response size for the given route
+func withMetrics(route string, handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		rw := &responseWriterWithMetrics{ResponseWriter: w}
+		defer func() {
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			metrics.ResponseBytes.WithLabelValues(route).Add(float64(rw.bytes))
+			metrics.RequestDuration.WithLabelValues(route, strconv.Itoa(status)).
+				Observe(time.Since(start).Seconds())
+		}()
+		handler(rw, r, p)
+	}
+}
+
+func (w *responseWriterWithMetrics) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriterWithMetrics) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher, see responseWriterWithStatus.Flush()
+func (w *responseWriterWithMetrics) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, see responseWriterWithStatus.Hijack()
+func (w *responseWriterWithMetrics) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, trace.NotImplemented("hijacking is not supported by the response writer")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gravitational/logging-app/cmd/adapter/metrics"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_lrClientWithMetrics_Query(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		cli  *testLrClient
+		want float64
+	}{
+		{name: "no error", ctx: context.Background(), cli: &testLrClient{query: queryErr(nil, nil)}, want: 0},
+		{name: "transport error", ctx: context.Background(), cli: &testLrClient{query: queryErr(errors.New("err"), nil)}, want: 1},
+		{name: "server error", ctx: context.Background(), cli: &testLrClient{query: queryErr(nil, errors.New("err"))}, want: 1},
+		{name: "canceled context", ctx: canceled, cli: &testLrClient{query: queryErr(context.Canceled, nil)}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.QueryErrors)
+			c := &lrClientWithMetrics{Client: tt.cli}
+			_ = c.Query(tt.ctx, &api.QueryRequest{}, &api.QueryResult{})
+			if got := testutil.ToFloat64(metrics.QueryErrors) - before; got != tt.want {
+				t.Errorf("lrClientWithMetrics.Query() errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withMetrics(t *testing.T) {
+	h := withMetrics("/test", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("12345"))
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil), nil)
+
+	if got := testutil.ToFloat64(metrics.ResponseBytes.WithLabelValues("/test")); got != 5 {
+		t.Errorf("withMetrics() response bytes = %v, want 5", got)
+	}
+	if got := testutil.CollectAndCount(metrics.RequestDuration); got != 1 {
+		t.Errorf("withMetrics() request duration series = %v, want 1", got)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LK4D4/joincontext"
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+type (
+	// Log volume statistics, the number of events
+	// per time bucket for each group (e.g. pod)
+	logStats struct {
+		Since    time.Time `json:"since"`
+		Until    time.Time `json:"until"`
+		Interval string    `json:"interval"`
+		GroupBy  string    `json:"groupBy"`
+		// Start times of the buckets
+		Buckets []time.Time `json:"buckets"`
+		// Groups sorted by total number of events (descending)
+		Groups []*statsGroup `json:"groups"`
+	}
+
+	// Number of events of the particular group
+	statsGroup struct {
+		Name  string `json:"name"`
+		Total int    `json:"total"`
+		// Number of events per bucket, aligned with logStats.Buckets
+		Counts []int `json:"counts"`
+	}
+
+	// Counts the events per time bucket and group
+	statsCounter struct {
+		since    time.Time
+		interval time.Duration
+		buckets  int
+		// Logrange field (or tag) name to group the events by
+		field  string
+		groups map[string]*statsGroup
+	}
+)
+
+const (
+	// Log stats default bucket interval
+	defaultStatsInterval = time.Minute
+
+	// Log stats default time range, if 'since' is not specified
+	defaultStatsRange = time.Hour
+
+	// Log stats default grouping key
+	defaultStatsGroupBy = "pod"
+
+	// Log stats default number of returned groups
+	defaultStatsTop = 10
+
+	// Log stats maximum number of buckets
+	statsBucketsMax = 1440
+
+	// Log stats maximum number of scanned lines
+	statsLinesMax = 500000000
+)
+
+// "/v1/stats" api handler, counts the events matching the given query
+// per time bucket, grouped by pod, container or any other field/tag
+func (s *Server) statsHandler(ctx context.Context,
+	rw http.ResponseWriter, rq *http.Request, p httprouter.Params) error {
+	queryParam := strings.TrimSpace(rq.URL.Query().Get("query"))
+	now := time.Now()
+	tr, err := getTimeRangeParams(rq, now)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if tr.Until.IsZero() {
+		tr.Until = now
+	}
+	if tr.Since.IsZero() {
+		tr.Since = tr.Until.Add(-defaultStatsRange)
+	}
+	if tr.Since.After(tr.Until) {
+		return trace.BadParameter("invalid since=%v: must not be after until=%v", tr.Since, tr.Until)
+	}
+
+	interval, err := getStatsIntervalParam(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	top, err := getStatsTopParam(rq)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	groupBy := strings.TrimSpace(rq.URL.Query().Get("groupBy"))
+	if groupBy == "" {
+		groupBy = defaultStatsGroupBy
+	}
+
+	sc, err := newStatsCounter(tr, interval, statsGroupField(groupBy))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	// build Logrange query
+	qr := s.buildQueryRequest(queryParam, getQueryOptions(rq), tr, "head", statsLinesMax, 0)
+	s.logger.Info("stats(): Query=", qr.Query)
+
+	// join contexts to handle both server interruption (e.g. SIGINT) and transport err (e.g. broken pipe)
+	jctx, cancel := joincontext.Join(ctx, rq.Context())
+	defer cancel()
+
+	err = api.Select(jctx, s.lrClient, qr, false,
+		func(res *api.QueryResult) {
+			sc.add(res.Events)
+		})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	stats := sc.stats(top)
+	stats.GroupBy = groupBy
+	return writeJson(rw, stats)
+}
+
+// Returns 'interval' request param, which is positive duration (e.g. "1m")
+func getStatsIntervalParam(rq *http.Request) (time.Duration, error) {
+	v := strings.TrimSpace(rq.URL.Query().Get("interval"))
+	if v == "" {
+		return defaultStatsInterval, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, trace.BadParameter("invalid interval: %q is not positive duration", v)
+	}
+	return d, nil
+}
+
+// Returns 'top' request param, which is the number of the biggest groups to return,
+// 0 means all the groups
+func getStatsTopParam(rq *http.Request) (int, error) {
+	v := strings.TrimSpace(rq.URL.Query().Get("top"))
+	if v == "" {
+		return defaultStatsTop, nil
+	}
+	top, err := strconv.Atoi(v)
+	if err != nil || top < 0 {
+		return 0, trace.BadParameter("invalid top: %q is not non-negative integer", v)
+	}
+	return top, nil
+}
+
+// Returns Logrange field name to group the events by,
+// 'Gravity log query' keys are translated, the rest is used as is
+func statsGroupField(groupBy string) string {
+	if f := query.KeyToField(groupBy); f != "" {
+		return f
+	}
+	return groupBy
+}
+
+// Creates stats counter for the given time range split into buckets of the given interval
+func newStatsCounter(tr query.TimeRange, interval time.Duration, field string) (*statsCounter, error) {
+	// Sub saturates at the maximum duration, so the count can't overflow
+	// even for the widest accepted time range
+	d := tr.Until.Sub(tr.Since)
+	if d < 0 {
+		return nil, trace.BadParameter("invalid time range: since=%v is after until=%v", tr.Since, tr.Until)
+	}
+	n := d / interval
+	if d%interval != 0 {
+		n++
+	}
+	if n > statsBucketsMax {
+		return nil, trace.BadParameter("too many buckets %v (max %v), increase interval or narrow time range",
+			int64(n), statsBucketsMax)
+	}
+	buckets := int(n)
+	if buckets == 0 {
+		buckets = 1
+	}
+	return &statsCounter{since: tr.Since, interval: interval, buckets: buckets,
+		field: field, groups: make(map[string]*statsGroup)}, nil
+}
+
+// Counts the given events, the events outside the time range are ignored
+func (sc *statsCounter) add(evs []*api.LogEvent) {
+	for _, e := range evs {
+		offs := time.Unix(0, e.Timestamp).Sub(sc.since)
+		idx := int(offs / sc.interval)
+		if offs < 0 || idx > sc.buckets {
+			continue
+		}
+		if idx == sc.buckets { // the range end is inclusive
+			idx--
+		}
+
+		name := sc.groupName(e)
+		g, ok := sc.groups[name]
+		if !ok {
+			g = &statsGroup{Name: name, Counts: make([]int, sc.buckets)}
+			sc.groups[name] = g
+		}
+		g.Counts[idx]++
+		g.Total++
+	}
+}
+
+// Returns the event group name, which is the field value
+// or the tag value (if the event has no such field)
+func (sc *statsCounter) groupName(e *api.LogEvent) string {
+	if v, ok := parseCSVIntoMap(e.Fields)[sc.field]; ok {
+		return v
+	}
+	return parseCSVIntoMap(e.Tags)[sc.field]
+}
+
+// Returns the counted stats, only 'top' biggest groups are returned (all if top is 0)
+func (sc *statsCounter) stats(top int) *logStats {
+	stats := &logStats{
+		Since:    sc.since,
+		Until:    sc.since.Add(time.Duration(sc.buckets) * sc.interval),
+		Interval: sc.interval.String(),
+		Buckets:  make([]time.Time, sc.buckets),
+		Groups:   make([]*statsGroup, 0, len(sc.groups)),
+	}
+	for i := range stats.Buckets {
+		stats.Buckets[i] = sc.since.Add(time.Duration(i) * sc.interval)
+	}
+	for _, g := range sc.groups {
+		stats.Groups = append(stats.Groups, g)
+	}
+	sort.Slice(stats.Groups, func(i, j int) bool {
+		gi, gj := stats.Groups[i], stats.Groups[j]
+		return gi.Total > gj.Total || gi.Total == gj.Total && gi.Name < gj.Name
+	})
+	if top > 0 && len(stats.Groups) > top {
+		stats.Groups = stats.Groups[:top]
+	}
+	return stats
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	log "github.com/gravitational/logrus"
+	"github.com/logrange/logrange/api"
+)
+
+func Test_statsCounter(t *testing.T) {
+	since := time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC)
+	ts := func(d time.Duration) int64 { return since.Add(d).UnixNano() }
+	sc, err := newStatsCounter(query.TimeRange{Since: since, Until: since.Add(3 * time.Minute)},
+		time.Minute, statsGroupField("pod"))
+	if err != nil {
+		t.Fatalf("newStatsCounter() error = %v", err)
+	}
+
+	sc.add([]*api.LogEvent{
+		{Timestamp: ts(0), Fields: "pod=p1"},
+		{Timestamp: ts(30 * time.Second), Fields: "pod=p1"},
+		{Timestamp: ts(time.Minute), Fields: "pod=p2"},
+		{Timestamp: ts(2 * time.Minute), Tags: "pod=p3"},
+		{Timestamp: ts(3 * time.Minute), Fields: "pod=p1"},  // the range end, counted in the last bucket
+		{Timestamp: ts(-time.Second), Fields: "pod=p1"},     // before the range, ignored
+		{Timestamp: ts(5 * time.Minute), Fields: "pod=p2"},  // after the range, ignored
+		{Timestamp: ts(2 * time.Minute), Fields: "file=f1"}, // no pod
+	})
+
+	wantBuckets := []time.Time{since, since.Add(time.Minute), since.Add(2 * time.Minute)}
+	tests := []struct {
+		name string
+		top  int
+		want []*statsGroup
+	}{
+		{
+			name: "all groups",
+			top:  0,
+			want: []*statsGroup{
+				{Name: "p1", Total: 3, Counts: []int{2, 0, 1}},
+				{Name: "", Total: 1, Counts: []int{0, 0, 1}},
+				{Name: "p2", Total: 1, Counts: []int{0, 1, 0}},
+				{Name: "p3", Total: 1, Counts: []int{0, 0, 1}},
+			},
+		},
+		{
+			name: "top groups",
+			top:  2,
+			want: []*statsGroup{
+				{Name: "p1", Total: 3, Counts: []int{2, 0, 1}},
+				{Name: "", Total: 1, Counts: []int{0, 0, 1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sc.stats(tt.top)
+			if !reflect.DeepEqual(got.Buckets, wantBuckets) {
+				t.Errorf("statsCounter.stats() buckets = %v, want %v", got.Buckets, wantBuckets)
+			}
+			if !reflect.DeepEqual(got.Groups, tt.want) {
+				gotJson, _ := json.Marshal(got.Groups)
+				wantJson, _ := json.Marshal(tt.want)
+				t.Errorf("statsCounter.stats() groups = %s, want %s", gotJson, wantJson)
+			}
+		})
+	}
+}
+
+func Test_newStatsCounter(t *testing.T) {
+	since := time.Date(2019, time.January, 1, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		since       time.Time
+		until       time.Time
+		interval    time.Duration
+		wantBuckets int
+		wantErr     bool
+	}{
+		{name: "exact", until: since.Add(time.Hour), interval: time.Minute, wantBuckets: 60},
+		{name: "partial last bucket", until: since.Add(61 * time.Second), interval: time.Minute, wantBuckets: 2},
+		{name: "empty range", until: since, interval: time.Minute, wantBuckets: 1},
+		{name: "too many buckets", until: since.Add(48 * time.Hour), interval: time.Minute, wantErr: true},
+		{name: "until before since", until: since.Add(-time.Minute), interval: time.Minute, wantErr: true},
+		{name: "widest range", since: time.Date(1678, time.January, 1, 0, 0, 0, 0, time.UTC),
+			until: time.Date(2262, time.January, 1, 0, 0, 0, 0, time.UTC), interval: time.Minute, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := since
+			if !tt.since.IsZero() {
+				from = tt.since
+			}
+			sc, err := newStatsCounter(query.TimeRange{Since: from, Until: tt.until}, tt.interval, "pod")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newStatsCounter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && sc.buckets != tt.wantBuckets {
+				t.Errorf("newStatsCounter() buckets = %v, want %v", sc.buckets, tt.wantBuckets)
+			}
+		})
+	}
+}
+
+func TestServer_statsHandler(t *testing.T) {
+	cli := &testLrClient{
+		results: []*api.QueryResult{
+			{Events: []*api.LogEvent{
+				{Timestamp: time.Date(2019, time.January, 1, 1, 0, 30, 0, time.UTC).UnixNano(), Fields: "cname=c1"},
+				{Timestamp: time.Date(2019, time.January, 1, 1, 1, 30, 0, time.UTC).UnixNano(), Fields: "cname=c1"},
+			}},
+			{},
+		},
+	}
+	s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "stats")}
+
+	rq := httptest.NewRequest("GET", "/v1/stats?query=pod:p1&groupBy=container&interval=1m"+
+		"&since=2019-01-01T01:00:00Z&until=2019-01-01T01:02:00Z", nil)
+	rw := httptest.NewRecorder()
+	if err := s.statsHandler(context.Background(), rw, rq, nil); err != nil {
+		t.Fatalf("Server.statsHandler() error = %v", err)
+	}
+
+	if len(cli.reqs) == 0 || !strings.HasPrefix(cli.reqs[0].Query, "SELECT FROM partition WHERE fields:pod=\"p1\" AND ts >= ") {
+		t.Fatalf("Server.statsHandler() queries = %v", cli.reqs)
+	}
+
+	var got logStats
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Server.statsHandler() body = %s, err = %v", rw.Body.String(), err)
+	}
+	want := []*statsGroup{{Name: "c1", Total: 2, Counts: []int{1, 1}}}
+	if got.GroupBy != "container" || got.Interval != "1m0s" || !reflect.DeepEqual(got.Groups, want) {
+		t.Errorf("Server.statsHandler() = %s", rw.Body.String())
+	}
+
+	// bad params
+	for _, params := range []string{"interval=-1m", "interval=1ms&since=1h", "top=x", "since=x",
+		"since=0001-01-01T00:00:00Z", "since=1600-01-01T00:00:00Z",
+		"since=1678-01-01T00:00:00Z&until=2262-01-01T00:00:00Z"} {
+		err := s.statsHandler(context.Background(), httptest.NewRecorder(),
+			httptest.NewRequest("GET", "/v1/stats?"+params, nil), nil)
+		if err == nil {
+			t.Errorf("Server.statsHandler(%v) error = nil, want bad parameter", params)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gravitational/logging-app/cmd/adapter/query"
+	"github.com/gravitational/trace"
+)
+
+type (
+	// Error of the invalid 'Gravity log query', it's returned
+	// in strict mode instead of searching the query as literal text
+	queryParseError struct {
+		trace.BadParameterError
+		perr *query.ParseError
+	}
+)
+
+// Returns error if the 'strict' param is true and the given query is invalid
+func checkStrictQuery(rq *http.Request, q string) error {
+	if strict, _ := strconv.ParseBool(rq.URL.Query().Get("strict")); !strict {
+		return nil
+	}
+	if perr := query.Validate(q); perr != nil {
+		return trace.Wrap(&queryParseError{
+			BadParameterError: trace.BadParameterError{Message: "invalid query: " + perr.Error()},
+			perr:              perr,
+		})
+	}
+	return nil
+}
+
+// Writes the query parse error as JSON (message, position and expected tokens)
+// with 400 status code, returns false if the given error is not a query parse error
+func writeQueryParseError(rw http.ResponseWriter, err error) bool {
+	qerr, ok := trace.Unwrap(err).(*queryParseError)
+	if !ok {
+		return false
+	}
+	// no HTML escaping, so that the expected tokens (e.g. "<value>") are readable
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(qerr.perr); err != nil {
+		return false
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusBadRequest)
+	_, _ = rw.Write(buf.Bytes())
+	return true
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/gravitational/logrus"
+	"github.com/julienschmidt/httprouter"
+	"github.com/logrange/logrange/api"
+)
+
+func TestServer_strictQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "invalid query",
+			url:        "/v1/log?strict=true&query=pod:foo%20andd%20container:bar",
+			wantStatus: http.StatusBadRequest,
+			wantBody: `{"message":"unexpected token \"andd\"","offset":8,"line":1,"column":9,` +
+				`"expected":["AND","OR","<EOF>"]}` + "\n",
+		},
+		{
+			name:       "invalid download query",
+			url:        "/v1/download?strict=1&query=pod:",
+			wantStatus: http.StatusBadRequest,
+			wantBody: `{"message":"unexpected end of query","offset":4,"line":1,"column":5,` +
+				`"expected":["<glob>","<string>","<word>","<timestamp>","<keyword>"]}` + "\n",
+		},
+		{
+			name:       "not strict",
+			url:        "/v1/log?query=pod:foo%20andd%20container:bar",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "valid query",
+			url:        "/v1/log?strict=true&query=pod:foo",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := &testLrClient{results: []*api.QueryResult{{}, {}}}
+			s := &Server{lrClient: cli, lrPartition: "partition", logger: log.WithField("test", "strict")}
+			router := httprouter.New()
+			s.handle(context.Background(), router, "/v1/log", s.logHandler)
+			s.handle(context.Background(), router, "/v1/download", s.downloadHandler)
+
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, httptest.NewRequest("GET", tt.url, nil))
+			if rw.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rw.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rw.Body.String() != tt.wantBody {
+				t.Errorf("body = %v, want %v", rw.Body.String(), tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusBadRequest && len(cli.reqs) > 0 {
+				t.Errorf("queries = %v, want none", cli.reqs)
+			}
+		})
+	}
+}
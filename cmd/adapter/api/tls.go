@@ -0,0 +1,211 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/gravitational/logrus"
+	"github.com/gravitational/trace"
+	"github.com/logrange/logrange/pkg/utils"
+)
+
+type (
+	// Represents API server TLS config
+	TLSConfig struct {
+		// Path to the server certificate file
+		CertFile string
+		// Path to the server private key file
+		KeyFile string
+		// Path to the CA certificate(s) file, if it's given the server
+		// verifies client certificates during TLS handshake and requires
+		// them for all the requests, except the probes ones (mTLS)
+		ClientCAFile string
+	}
+
+	// Keeps the server certificate loaded from the files and reloads
+	// it when the files are modified, so that the rotated certificate is
+	// picked up without the server restart
+	certReloader struct {
+		certFile string
+		keyFile  string
+
+		lock     sync.Mutex
+		cert     *tls.Certificate
+		modTimes [2]time.Time
+
+		logger *log.Entry
+	}
+)
+
+// NewTLSConfig creates server TLS config for the given config, returns nil if
+// the config is nil. If authClientCAFile (the client CA of the Authenticator) is given
+// and the config has no client CA, the client certificates are requested but not verified
+// during TLS handshake (they're verified by the Authenticator later). If the config has client CA,
+// the given client certificates are verified against it (and authClientCAFile one, if any),
+// but they're not required during TLS handshake, so that the probes work without them,
+// see WithClientCertAuth().
+func NewTLSConfig(cfg *TLSConfig, authClientCAFile string) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	cr, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+	}
+
+	if cfg.ClientCAFile != "" {
+		tlsCfg.ClientCAs = x509.NewCertPool()
+		for _, caFile := range []string{cfg.ClientCAFile, authClientCAFile} {
+			if caFile == "" {
+				continue
+			}
+			if err := appendCertsFromFile(tlsCfg.ClientCAs, caFile); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	} else if authClientCAFile != "" {
+		tlsCfg.ClientAuth = tls.RequestClientCert
+	}
+	return tlsCfg, nil
+}
+
+// WithClientCertAuth returns the authenticator, which requires the client certificate
+// signed by the client CA of the given config (if any) in addition to the given
+// authenticator (nil means no other authentication)
+func WithClientCertAuth(cfg *TLSConfig, auth Authenticator) (Authenticator, error) {
+	if cfg == nil || cfg.ClientCAFile == "" {
+		return auth, nil
+	}
+	certAuth, err := newCertAuthenticator(cfg.ClientCAFile, nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if auth == nil {
+		return certAuth, nil
+	}
+	return allAuthenticator{certAuth, auth}, nil
+}
+
+// Appends the certificates of the given PEM file to the pool
+func appendCertsFromFile(pool *x509.CertPool, file string) error {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return trace.BadParameter("no certificates found in %v", file)
+	}
+	return nil
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   log.WithField(trace.Component, "logging-app.api.tls"),
+	}
+	if err := cr.reloadIfModified(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return cr, nil
+}
+
+// Implements tls.Config.GetCertificate, in case of reload
+// failure the previously loaded certificate is returned
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := cr.reloadIfModified(); err != nil {
+		cr.logger.Error("Failed to reload certificate=", cr.certFile, ", key=", cr.keyFile, ", err=", err)
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	return cr.cert, nil
+}
+
+func (cr *certReloader) reloadIfModified() error {
+	var modTimes [2]time.Time
+	for i, f := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		modTimes[i] = fi.ModTime()
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if cr.cert != nil && modTimes == cr.modTimes {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if cr.cert != nil {
+		cr.logger.Info("Reloaded certificate=", cr.certFile, ", key=", cr.keyFile)
+	}
+	cr.cert, cr.modTimes = &cert, modTimes
+	return nil
+}
+
+// Merges current config with the given one
+func (cfg *TLSConfig) Merge(other *TLSConfig) {
+	if other == nil {
+		return
+	}
+
+	if other.CertFile != "" {
+		cfg.CertFile = other.CertFile
+	}
+	if other.KeyFile != "" {
+		cfg.KeyFile = other.KeyFile
+	}
+	if other.ClientCAFile != "" {
+		cfg.ClientCAFile = other.ClientCAFile
+	}
+}
+
+// Checks whether current config is valid and safe to use
+func (cfg *TLSConfig) Check() error {
+	if cfg.CertFile == "" {
+		return trace.BadParameter("invalid CertFile: must be non-empty")
+	}
+	if cfg.KeyFile == "" {
+		return trace.BadParameter("invalid KeyFile: must be non-empty")
+	}
+	if _, err := NewTLSConfig(cfg, ""); err != nil {
+		return trace.BadParameter("invalid TLS config: %v", err)
+	}
+	return nil
+}
+
+func (cfg *TLSConfig) String() string {
+	return utils.ToJsonStr(cfg)
+}
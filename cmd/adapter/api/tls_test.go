@@ -0,0 +1,191 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	caCert, caKey := newTestCert(t, "ca", nil, nil)
+	certFile, keyFile := writeTestKeyPair(t, caCert, caKey)
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	caFile := writeTmpFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})))
+	defer os.Remove(caFile)
+	authCACert, authCAKey := newTestCert(t, "auth-ca", nil, nil)
+	authCAFile := writeTmpFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authCACert.Raw})))
+	defer os.Remove(authCAFile)
+	clientCert, _ := newTestCert(t, "client", caCert, caKey)
+	authClientCert, _ := newTestCert(t, "auth-client", authCACert, authCAKey)
+
+	tests := []struct {
+		name             string
+		cfg              *TLSConfig
+		authClientCAFile string
+		wantClientAuth   tls.ClientAuthType
+		// the client certificates, which must be verified by the client CAs
+		wantVerified []*x509.Certificate
+	}{
+		{name: "no client cert ok", cfg: &TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			wantClientAuth: tls.NoClientCert},
+		{name: "request client cert ok", cfg: &TLSConfig{CertFile: certFile, KeyFile: keyFile},
+			authClientCAFile: authCAFile, wantClientAuth: tls.RequestClientCert},
+		{name: "mTLS ok", cfg: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			wantClientAuth: tls.VerifyClientCertIfGiven, wantVerified: []*x509.Certificate{clientCert}},
+		{name: "mTLS and auth client CA ok", cfg: &TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			authClientCAFile: authCAFile, wantClientAuth: tls.VerifyClientCertIfGiven,
+			wantVerified: []*x509.Certificate{clientCert, authClientCert}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTLSConfig(tt.cfg, tt.authClientCAFile)
+			if err != nil {
+				t.Fatalf("NewTLSConfig() error = %v", err)
+			}
+			if got.ClientAuth != tt.wantClientAuth {
+				t.Errorf("NewTLSConfig() ClientAuth = %v, want %v", got.ClientAuth, tt.wantClientAuth)
+			}
+			if cert, err := got.GetCertificate(nil); err != nil || cert == nil {
+				t.Errorf("NewTLSConfig() GetCertificate() = %v, %v", cert, err)
+			}
+			for _, cert := range tt.wantVerified {
+				opts := x509.VerifyOptions{Roots: got.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+				if _, err := cert.Verify(opts); err != nil {
+					t.Errorf("NewTLSConfig() ClientCAs don't verify %v: %v", cert.Subject.CommonName, err)
+				}
+			}
+		})
+	}
+
+	if _, err := NewTLSConfig(&TLSConfig{CertFile: certFile, KeyFile: caFile}, ""); err == nil {
+		t.Errorf("NewTLSConfig() expected error for invalid key")
+	}
+	if _, err := NewTLSConfig(&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}, keyFile); err == nil {
+		t.Errorf("NewTLSConfig() expected error for invalid auth client CA")
+	}
+}
+
+func TestWithClientCertAuth(t *testing.T) {
+	caCert, caKey := newTestCert(t, "ca", nil, nil)
+	okCert, _ := newTestCert(t, "client1", caCert, caKey)
+	caFile := writeTmpFile(t, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})))
+	defer os.Remove(caFile)
+
+	if auth, err := WithClientCertAuth(&TLSConfig{}, nil); err != nil || auth != nil {
+		t.Errorf("WithClientCertAuth() = %v, %v, want nil", auth, err)
+	}
+
+	denied := authFunc(func(*http.Request) error { return trace.AccessDenied("denied") })
+	ok := authFunc(func(*http.Request) error { return nil })
+	tests := []struct {
+		name    string
+		auth    Authenticator
+		cert    *x509.Certificate
+		wantErr func(error) bool
+	}{
+		{name: "cert only ok", cert: okCert},
+		{name: "cert and auth ok", auth: ok, cert: okCert},
+		{name: "no cert err", auth: ok, wantErr: IsUnauthenticated},
+		{name: "auth err", auth: denied, cert: okCert, wantErr: trace.IsAccessDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := WithClientCertAuth(&TLSConfig{ClientCAFile: caFile}, tt.auth)
+			if err != nil {
+				t.Fatalf("WithClientCertAuth() error = %v", err)
+			}
+			rq := httptest.NewRequest("GET", "/v1/log", nil)
+			if tt.cert != nil {
+				rq.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+			}
+			err = auth.Authenticate(rq)
+			if (tt.wantErr == nil && err != nil) || (tt.wantErr != nil && !tt.wantErr(err)) {
+				t.Errorf("WithClientCertAuth().Authenticate() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_certReloader(t *testing.T) {
+	cert1, key1 := newTestCert(t, "server1", nil, nil)
+	certFile, keyFile := writeTestKeyPair(t, cert1, key1)
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+	got, _ := cr.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(got.Certificate[0]); leaf.Subject.CommonName != "server1" {
+		t.Fatalf("certReloader.getCertificate() = %v, want server1", leaf.Subject.CommonName)
+	}
+
+	// rotate
+	cert2, key2 := newTestCert(t, "server2", nil, nil)
+	writeTestKeyPairTo(t, cert2, key2, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	_ = os.Chtimes(keyFile, future, future)
+
+	got, _ = cr.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(got.Certificate[0]); leaf.Subject.CommonName != "server2" {
+		t.Errorf("certReloader.getCertificate() = %v, want server2", leaf.Subject.CommonName)
+	}
+
+	// broken rotation keeps the previous certificate
+	_ = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	_ = os.Chtimes(keyFile, future, future)
+
+	got, _ = cr.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(got.Certificate[0]); leaf.Subject.CommonName != "server2" {
+		t.Errorf("certReloader.getCertificate() = %v, want server2", leaf.Subject.CommonName)
+	}
+}
+
+func writeTestKeyPair(t *testing.T, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	certFile, keyFile := writeTmpFile(t, ""), writeTmpFile(t, "")
+	writeTestKeyPairTo(t, cert, key, certFile, keyFile)
+	return certFile, keyFile
+}
+
+func writeTestKeyPairTo(t *testing.T, cert *x509.Certificate, key *ecdsa.PrivateKey, certFile, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatalf("ioutil.WriteFile() error = %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+type (
+	// Log download stream writer, writes the log data chunks
+	// as the entries (files) of the particular archive format
+	entryWriter interface {
+		// Writes the given data as the next entry
+		write(b []byte) error
+		// Writes the given data as the entry with the given name
+		writeEntry(name string, b []byte) error
+		// Finalizes the stream, so that it's the valid archive even if nothing has been written
+		close()
+	}
+
+	// Log download format, describes the response and creates the stream writer
+	downloadFormat struct {
+		// Format name as it's given in 'format' param
+		name string
+		// Response content type
+		contentType string
+		// Response attachment file name
+		fileName string
+		// Creates the stream writer for the given response
+		newWriter func(w io.Writer, entryPrfx string) entryWriter
+	}
+
+	// Compressed tarball writer
+	tarGzEntryWriter struct {
+		entryNum  int
+		entryPrfx string
+
+		gzWriter  *gzip.Writer
+		tarWriter *tar.Writer
+	}
+
+	// Zip archive writer
+	zipEntryWriter struct {
+		entryNum  int
+		entryPrfx string
+
+		zipWriter *zip.Writer
+	}
+
+	// Compressed NDJSON writer, all the entries are written
+	// one after another to the single gzip stream
+	ndjsonGzEntryWriter struct {
+		gzWriter *gzip.Writer
+	}
+)
+
+var (
+	// Supported log download formats, the first one is the default
+	downloadFormats = []*downloadFormat{
+		{
+			name:        "tar.gz",
+			contentType: "application/gzip",
+			fileName:    "logs.tar.gz",
+			newWriter: func(w io.Writer, entryPrfx string) entryWriter {
+				return newTarGzEntryWriter(w, entryPrfx)
+			},
+		},
+		{
+			name:        "zip",
+			contentType: "application/zip",
+			fileName:    "logs.zip",
+			newWriter: func(w io.Writer, entryPrfx string) entryWriter {
+				return newZipEntryWriter(w, entryPrfx)
+			},
+		},
+		{
+			name:        "ndjson.gz",
+			contentType: "application/gzip",
+			fileName:    "logs.ndjson.gz",
+			newWriter: func(w io.Writer, entryPrfx string) entryWriter {
+				return newNdjsonGzEntryWriter(w)
+			},
+		},
+	}
+
+	// Maps 'Accept' header media types to the log download formats
+	acceptToDownloadFormat = map[string]string{
+		"application/gzip":     "tar.gz",
+		"application/x-gzip":   "tar.gz",
+		"application/x-tar":    "tar.gz",
+		"application/x-gtar":   "tar.gz",
+		"application/zip":      "zip",
+		"application/x-ndjson": "ndjson.gz",
+	}
+)
+
+// Returns log download format requested by either 'format' param
+// or 'Accept' header (the param takes precedence), if none of them
+// is given or the header doesn't contain known types, the default format is returned.
+func getDownloadFormat(rq *http.Request) (*downloadFormat, error) {
+	if name := strings.TrimSpace(rq.URL.Query().Get("format")); name != "" {
+		if f := findDownloadFormat(name); f != nil {
+			return f, nil
+		}
+		return nil, trace.BadParameter("invalid format=%q: must be one of %v", name, downloadFormats)
+	}
+
+	for _, accept := range strings.Split(rq.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		if f := findDownloadFormat(acceptToDownloadFormat[mediaType]); f != nil {
+			return f, nil
+		}
+	}
+	return downloadFormats[0], nil
+}
+
+func findDownloadFormat(name string) *downloadFormat {
+	for _, f := range downloadFormats {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (f *downloadFormat) String() string {
+	return f.name
+}
+
+// Returns entry name for the given prefix and entry number,
+// the names are: "prefix", "prefix.0", "prefix.1", ...
+func entryName(entryPrfx string, entryNum int) string {
+	if entryNum > 0 {
+		return fmt.Sprintf("%v.%v", entryPrfx, entryNum-1)
+	}
+	return entryPrfx
+}
+
+func newTarGzEntryWriter(w io.Writer, entryPrfx string) *tarGzEntryWriter {
+	tgWriter := new(tarGzEntryWriter)
+	tgWriter.entryPrfx = entryPrfx
+	tgWriter.gzWriter = gzip.NewWriter(w)
+	tgWriter.tarWriter = tar.NewWriter(tgWriter.gzWriter)
+	return tgWriter
+}
+
+func (w *tarGzEntryWriter) write(b []byte) error {
+	return w.writeHeaderAndData(w.nextEntryHeader(len(b)), b)
+}
+
+func (w *tarGzEntryWriter) writeEntry(name string, b []byte) error {
+	w.entryNum++
+	return w.writeHeaderAndData(newTarHeader(name, len(b)), b)
+}
+
+func (w *tarGzEntryWriter) writeHeaderAndData(h *tar.Header, b []byte) error {
+	err := w.tarWriter.WriteHeader(h)
+	if err == nil {
+		_, err = w.tarWriter.Write(b)
+	}
+	return trace.Wrap(err)
+}
+
+func (w *tarGzEntryWriter) nextEntryHeader(size int) *tar.Header {
+	name := entryName(w.entryPrfx, w.entryNum)
+	w.entryNum++
+	return newTarHeader(name, size)
+}
+
+func newTarHeader(name string, size int) *tar.Header {
+	return &tar.Header{
+		Name:     name,
+		ModTime:  time.Now(),
+		Mode:     0777,
+		Typeflag: tar.TypeReg,
+		Size:     int64(size),
+	}
+}
+
+func (w *tarGzEntryWriter) close() {
+	_ = w.tarWriter.Close()
+	_ = w.gzWriter.Close()
+}
+
+func newZipEntryWriter(w io.Writer, entryPrfx string) *zipEntryWriter {
+	zWriter := new(zipEntryWriter)
+	zWriter.entryPrfx = entryPrfx
+	zWriter.zipWriter = zip.NewWriter(w)
+	return zWriter
+}
+
+func (w *zipEntryWriter) write(b []byte) error {
+	return w.writeEntry(entryName(w.entryPrfx, w.entryNum), b)
+}
+
+func (w *zipEntryWriter) writeEntry(name string, b []byte) error {
+	w.entryNum++
+	ew, err := w.zipWriter.CreateHeader(newZipHeader(name))
+	if err == nil {
+		_, err = ew.Write(b)
+	}
+	return trace.Wrap(err)
+}
+
+func newZipHeader(name string) *zip.FileHeader {
+	return &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+}
+
+func (w *zipEntryWriter) close() {
+	_ = w.zipWriter.Close()
+}
+
+func newNdjsonGzEntryWriter(w io.Writer) *ndjsonGzEntryWriter {
+	return &ndjsonGzEntryWriter{gzWriter: gzip.NewWriter(w)}
+}
+
+func (w *ndjsonGzEntryWriter) write(b []byte) error {
+	_, err := w.gzWriter.Write(b)
+	return trace.Wrap(err)
+}
+
+// The entries aren't separated in NDJSON stream, so the name is ignored
+func (w *ndjsonGzEntryWriter) writeEntry(name string, b []byte) error {
+	return w.write(b)
+}
+
+func (w *ndjsonGzEntryWriter) close() {
+	_ = w.gzWriter.Close()
+}
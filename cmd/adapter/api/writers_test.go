@@ -0,0 +1,178 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_tarGzEntryWriter_nextEntryHeader(t *testing.T) {
+	want := &tar.Header{Name: "prefix.0",
+		ModTime: time.Now(), Mode: 0777, Typeflag: tar.TypeReg, Size: 123}
+
+	w := &tarGzEntryWriter{
+		entryNum:  1,
+		entryPrfx: "prefix",
+	}
+
+	got := w.nextEntryHeader(123)
+	gtt := got.ModTime.Add(time.Minute)
+
+	want.ModTime = got.ModTime
+	if gtt.Before(want.ModTime) {
+		t.Errorf("tarGzEntryWriter.nextEntryHeader() = %v, want %v",
+			got.ModTime, want.ModTime)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tarGzEntryWriter.nextEntryHeader() = %v, want %v",
+			got, want)
+	}
+}
+
+func Test_tarGzEntryWriter_write(t *testing.T) {
+	want := []byte("test")
+
+	//write
+	wbuf := bytes.Buffer{}
+	gw := gzip.NewWriter(&wbuf)
+	tw := tar.NewWriter(gw)
+	w := &tarGzEntryWriter{entryNum: 123, entryPrfx: "prefix", gzWriter: gw, tarWriter: tw}
+	_ = w.write(want)
+	w.close()
+
+	//read
+	gzReader, _ := gzip.NewReader(&wbuf)
+	tarReader := tar.NewReader(gzReader)
+	h, _ := tarReader.Next()
+	rbuf := make([]byte, h.Size)
+	_, _ = tarReader.Read(rbuf)
+
+	//check
+	if !reflect.DeepEqual(rbuf, want) {
+		t.Errorf("tarGzEntryWriter.write() = %v, want %v", rbuf, want)
+	}
+}
+
+func Test_zipEntryWriter_write(t *testing.T) {
+	want := [][]byte{[]byte("test1"), []byte("test2")}
+
+	//write
+	wbuf := bytes.Buffer{}
+	w := newZipEntryWriter(&wbuf, "prefix")
+	for _, b := range want {
+		_ = w.write(b)
+	}
+	w.close()
+
+	//read
+	zipReader, err := zip.NewReader(bytes.NewReader(wbuf.Bytes()), int64(wbuf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	if len(zipReader.File) != len(want) {
+		t.Fatalf("zipEntryWriter.write() entries = %v, want %v", len(zipReader.File), len(want))
+	}
+	for i, f := range zipReader.File {
+		rc, _ := f.Open()
+		got, _ := ioutil.ReadAll(rc)
+		_ = rc.Close()
+
+		//check
+		if name := entryName("prefix", i); f.Name != name {
+			t.Errorf("zipEntryWriter.write() name = %v, want %v", f.Name, name)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("zipEntryWriter.write() = %v, want %v", got, want[i])
+		}
+	}
+}
+
+func Test_ndjsonGzEntryWriter_write(t *testing.T) {
+	want := []byte("test1\ntest2\n")
+
+	//write
+	wbuf := bytes.Buffer{}
+	w := newNdjsonGzEntryWriter(&wbuf)
+	_ = w.write(want[:6])
+	_ = w.write(want[6:])
+	w.close()
+
+	//read
+	gzReader, _ := gzip.NewReader(&wbuf)
+	got, _ := ioutil.ReadAll(gzReader)
+
+	//check
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ndjsonGzEntryWriter.write() = %v, want %v", got, want)
+	}
+}
+
+func Test_ndjsonGzEntryWriter_close(t *testing.T) {
+	//write nothing
+	wbuf := bytes.Buffer{}
+	w := newNdjsonGzEntryWriter(&wbuf)
+	w.close()
+
+	//read
+	gzReader, err := gzip.NewReader(&wbuf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	got, err := ioutil.ReadAll(gzReader)
+
+	//check
+	if err != nil || len(got) != 0 {
+		t.Errorf("ndjsonGzEntryWriter.close() = %v, %v, want empty gzip stream", got, err)
+	}
+}
+
+func Test_getDownloadFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{name: "default ok", want: "tar.gz"},
+		{name: "format param ok", params: "format=zip", accept: "application/x-ndjson", want: "zip"},
+		{name: "accept header ok", accept: "text/html, application/x-ndjson;q=0.9", want: "ndjson.gz"},
+		{name: "unknown accept header ok", accept: "*/*", want: "tar.gz"},
+		{name: "unknown format err", params: "format=rar", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/v1/download?"+tt.params, nil)
+			rq.Header.Set("Accept", tt.accept)
+			got, err := getDownloadFormat(rq)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getDownloadFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.name != tt.want {
+				t.Errorf("getDownloadFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,12 +19,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/gravitational/logging-app/cmd/adapter/api"
 	"github.com/gravitational/logging-app/cmd/adapter/k8s"
+	"github.com/gravitational/logging-app/cmd/adapter/query"
 	"github.com/gravitational/trace"
 	"github.com/logrange/logrange/pkg/forwarder"
 	"github.com/logrange/logrange/pkg/utils"
 	"github.com/logrange/range/pkg/transport"
 	"io/ioutil"
+	"net/url"
 )
 
 type (
@@ -33,8 +36,21 @@ type (
 	gravity struct {
 		// Address on which http server listens
 		ApiListenAddr string
+		// Address on which the probes are served over plaintext HTTP, optional,
+		// the probes are served on ApiListenAddr as well
+		ProbeListenAddr string
 		// K8s config
 		Kubernetes *k8s.Config
+		// API authentication config, optional
+		Auth *api.AuthConfig
+		// API TLS config, optional
+		Tls *api.TLSConfig
+		// Mapping of 'Gravity log query' keys to Logrange fields (e.g. "node": "node"),
+		// optional, merged with the default mapping, empty field removes the key
+		QueryKeys map[string]string
+		// Origins (e.g. "https://grafana.example.com") allowed to open Loki tail WebSocket
+		// besides the API host itself, optional
+		LokiTailOrigins []string
 	}
 
 	// Describe a query, which should be called periodically
@@ -154,6 +170,32 @@ func (g *gravity) merge(other *gravity) {
 	if other.ApiListenAddr != "" {
 		g.ApiListenAddr = other.ApiListenAddr
 	}
+	if other.ProbeListenAddr != "" {
+		g.ProbeListenAddr = other.ProbeListenAddr
+	}
+	if other.Auth != nil {
+		if g.Auth == nil {
+			g.Auth = &api.AuthConfig{}
+		}
+		g.Auth.Merge(other.Auth)
+	}
+	if other.Tls != nil {
+		if g.Tls == nil {
+			g.Tls = &api.TLSConfig{}
+		}
+		g.Tls.Merge(other.Tls)
+	}
+	if len(other.LokiTailOrigins) > 0 {
+		g.LokiTailOrigins = other.LokiTailOrigins
+	}
+	if len(other.QueryKeys) > 0 {
+		if g.QueryKeys == nil {
+			g.QueryKeys = make(map[string]string, len(other.QueryKeys))
+		}
+		for k, f := range other.QueryKeys {
+			g.QueryKeys[k] = f
+		}
+	}
 }
 
 func (g *gravity) check() error {
@@ -166,6 +208,29 @@ func (g *gravity) check() error {
 	if g.ApiListenAddr == "" {
 		return trace.BadParameter("invalid ApiListenAddr: must be non-empty")
 	}
+	if g.Auth != nil {
+		// the client certificates are sent only over TLS
+		if g.Auth.ClientCAFile != "" && g.Tls == nil {
+			return trace.BadParameter("invalid Auth=%v: ClientCAFile requires Tls", g.Auth)
+		}
+		if err := g.Auth.Check(); err != nil {
+			return trace.BadParameter("invalid Auth=%v: %v", g.Auth, err)
+		}
+	}
+	if g.Tls != nil {
+		if err := g.Tls.Check(); err != nil {
+			return trace.BadParameter("invalid Tls=%v: %v", g.Tls, err)
+		}
+	}
+	for _, o := range g.LokiTailOrigins {
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" {
+			return trace.BadParameter("invalid LokiTailOrigins=%v: %q must be scheme://host[:port]",
+				g.LokiTailOrigins, o)
+		}
+	}
+	if err := query.CheckKeyToField(g.QueryKeys); err != nil {
+		return trace.BadParameter("invalid QueryKeys=%v: %v", g.QueryKeys, err)
+	}
 	return nil
 }
 
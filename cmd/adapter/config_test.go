@@ -27,6 +27,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gravitational/logging-app/cmd/adapter/api"
 	"github.com/gravitational/logging-app/cmd/adapter/k8s"
 	"github.com/logrange/range/pkg/transport"
 )
@@ -186,8 +187,10 @@ func TestConfig_Check(t *testing.T) {
 func Test_gravity_merge(t *testing.T) {
 	defaultGravityCfg := newDefaultGravityConfig()
 	mergeGravityCfg := &gravity{
-		ApiListenAddr: "127.0.0.123:1234",
-		Kubernetes:    &k8s.Config{Namespace: "gravity", ForwarderConfigMapName: "cmName"},
+		ApiListenAddr:   "127.0.0.123:1234",
+		ProbeListenAddr: "127.0.0.123:1235",
+		Kubernetes:      &k8s.Config{Namespace: "gravity", ForwarderConfigMapName: "cmName"},
+		LokiTailOrigins: []string{"https://grafana.example.com"},
 	}
 
 	g := &gravity{
@@ -200,10 +203,46 @@ func Test_gravity_merge(t *testing.T) {
 	}
 }
 
+func Test_gravity_merge_auth(t *testing.T) {
+	g := newDefaultGravityConfig()
+	g.merge(&gravity{Auth: &api.AuthConfig{TokensFile: "tokens"}})
+	g.merge(&gravity{Auth: &api.AuthConfig{ClientCAFile: "ca"}})
+
+	want := &api.AuthConfig{TokensFile: "tokens", ClientCAFile: "ca"}
+	if !reflect.DeepEqual(g.Auth, want) {
+		t.Errorf("gravity.merge() Auth = %v, want %v", g.Auth, want)
+	}
+
+	if err := g.check(); err == nil || !strings.Contains(err.Error(), "invalid Auth") {
+		t.Errorf("gravity.check() error = %v, wantErr invalid Auth", err)
+	}
+}
+
+func Test_gravity_merge_queryKeys(t *testing.T) {
+	g := newDefaultGravityConfig()
+	g.merge(&gravity{QueryKeys: map[string]string{"node": "host", "level": "lvl"}})
+	g.merge(&gravity{QueryKeys: map[string]string{"level": ""}})
+
+	want := map[string]string{"node": "host", "level": ""}
+	if !reflect.DeepEqual(g.QueryKeys, want) {
+		t.Errorf("gravity.merge() QueryKeys = %v, want %v", g.QueryKeys, want)
+	}
+	if err := g.check(); err != nil {
+		t.Errorf("gravity.check() error = %v", err)
+	}
+
+	g.merge(&gravity{QueryKeys: map[string]string{"since": "ts"}})
+	if err := g.check(); err == nil || !strings.Contains(err.Error(), "invalid QueryKeys") {
+		t.Errorf("gravity.check() error = %v, wantErr invalid QueryKeys", err)
+	}
+}
+
 func Test_gravity_check(t *testing.T) {
 	type fields struct {
-		ApiListenAddr string
-		Kubernetes    *k8s.Config
+		ApiListenAddr   string
+		Kubernetes      *k8s.Config
+		Auth            *api.AuthConfig
+		LokiTailOrigins []string
 	}
 
 	defaultGravityCfg := newDefaultGravityConfig()
@@ -236,12 +275,32 @@ func Test_gravity_check(t *testing.T) {
 			},
 			wantErr: errors.New("invalid Kubernetes"),
 		},
+		{
+			name: "check invalid LokiTailOrigins err",
+			fields: fields{
+				ApiListenAddr:   defaultGravityCfg.ApiListenAddr,
+				Kubernetes:      defaultGravityCfg.Kubernetes,
+				LokiTailOrigins: []string{"https://grafana.example.com", "grafana.example.com"},
+			},
+			wantErr: errors.New("invalid LokiTailOrigins"),
+		},
+		{
+			name: "check Auth ClientCAFile without Tls err",
+			fields: fields{
+				ApiListenAddr: defaultGravityCfg.ApiListenAddr,
+				Kubernetes:    defaultGravityCfg.Kubernetes,
+				Auth:          &api.AuthConfig{ClientCAFile: "ca.pem"},
+			},
+			wantErr: errors.New("ClientCAFile requires Tls"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := &gravity{
-				ApiListenAddr: tt.fields.ApiListenAddr,
-				Kubernetes:    tt.fields.Kubernetes,
+				ApiListenAddr:   tt.fields.ApiListenAddr,
+				Kubernetes:      tt.fields.Kubernetes,
+				Auth:            tt.fields.Auth,
+				LokiTailOrigins: tt.fields.LokiTailOrigins,
 			}
 			if err := g.check(); (err == nil && tt.wantErr != nil) ||
 				(err != nil && !strings.Contains(err.Error(), tt.wantErr.Error())) {
@@ -114,19 +114,19 @@ func NewClient(ctx context.Context, gravityK8sCfg *Config, lograngeK8sCfg *Confi
 // is stored in its own place and has its own format
 // (and we can't break backward compatibility for now), at the same time Logrange
 // has different place to store this info and slightly different format...
-func (cli *Client) SyncForwarders(ctx context.Context) {
+func (cli *Client) SyncForwarders(ctx context.Context) error {
 	cli.logger.Debug("sync(): Getting Logrange forwarder config...")
 	lrFwdCfg, err := cli.getLograngeForwarderCfg()
 	if err != nil {
 		cli.logger.Error("sync(): Err=", err)
-		return
+		return trace.Wrap(err)
 	}
 
 	cli.logger.Debug("sync(): Getting Gravity forwarder config...")
 	grFwdCfgs, err := cli.getGravityForwarderConfig()
 	if err != nil {
 		cli.logger.Error("sync(): Err=", err)
-		return
+		return trace.Wrap(err)
 	}
 
 	cli.logger.Debug("sync(): Filtering invalid configs...")
@@ -136,14 +136,16 @@ func (cli *Client) SyncForwarders(ctx context.Context) {
 	newFwdCfg, err := cli.mergeFwdConfigs(lrFwdCfg.Forwarder, grFwdCfgs, cli.lograngeFwdTmpl)
 	if err != nil {
 		cli.logger.Error("sync(): Err=", err)
-		return
+		return trace.Wrap(err)
 	}
 
 	cli.logger.Info("sync(): Updating Logrange forwarder config: from=", lrFwdCfg.Forwarder, " to=", newFwdCfg)
 	lrFwdCfg.Forwarder = newFwdCfg
 	if err = cli.updateLograngeFwdCfg(lrFwdCfg); err != nil {
 		cli.logger.Error("sync(): Err=", err)
+		return trace.Wrap(err)
 	}
+	return nil
 }
 
 // Filters Gravity forwarder configs which can't be translated
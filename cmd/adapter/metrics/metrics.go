@@ -0,0 +1,103 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// Prefix of all the adapter metrics names
+	namespace = "logging_app"
+
+	// Sync result label values
+	SyncSuccess = "success"
+	SyncFailure = "failure"
+)
+
+var (
+	// API requests latency per route and HTTP status code
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "API requests latency in seconds.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"route", "code"})
+
+	// API response bytes per route
+	ResponseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "response_bytes_total",
+		Help:      "Number of API response body bytes written.",
+	}, []string{"route"})
+
+	// Logrange query errors
+	QueryErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logrange",
+		Name:      "query_errors_total",
+		Help:      "Number of failed Logrange queries.",
+	})
+
+	// Forwarders sync runs per result (success or failure)
+	SyncRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "runs_total",
+		Help:      "Number of forwarders sync runs by result.",
+	}, []string{"result"})
+
+	// Time of the last successful forwarders sync
+	SyncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "sync",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful forwarders sync.",
+	})
+
+	// Cron query runs per query
+	CronQueryRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cron",
+		Name:      "query_runs_total",
+		Help:      "Number of cron query runs.",
+	}, []string{"query"})
+
+	// Cron query errors per query
+	CronQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cron",
+		Name:      "query_errors_total",
+		Help:      "Number of failed cron query runs.",
+	}, []string{"query"})
+)
+
+func init() {
+	prometheus.MustRegister(RequestDuration, ResponseBytes, QueryErrors,
+		SyncRuns, SyncLastSuccess, CronQueryRuns, CronQueryErrors)
+}
+
+// Handler returns http handler which exposes the registered
+// metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/lexer"
+)
+
+const (
+	// Name of the end of the query in the expected tokens
+	expectedEOF = "<EOF>"
+)
+
+var (
+	// Participle's syntax error, e.g. `unexpected "x" (expected ":" | <ident>)`
+	unexpectedErrorRe = regexp.MustCompile(`^unexpected ("(?:[^"\\]|\\.)*") \(expected (.*)\)$`)
+
+	// Token literal (e.g. `":"`) or type (e.g. `<ident>`) in participle's expected grammar
+	expectedTokenRe = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|<\w+>`)
+
+	// Tokens which can follow the complete query, participle reports
+	// the extra token after it without the expected ones
+	expectedAfterQuery = []string{"AND", "OR", expectedEOF}
+)
+
+// Validate checks the given 'Gravity log query', returns nil if the query is valid (or empty),
+// the error otherwise. It's what BuildLqlQuery uses literal text search for.
+func Validate(grQuery string) *ParseError {
+	if grQuery == "" {
+		return nil
+	}
+	if _, err := parseGravityQuery(grQuery); err != nil {
+		return toParseError(grQuery, err)
+	}
+	return nil
+}
+
+// Error returns the error message along with the position
+func (e *ParseError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s (expected %s)", e.Line, e.Column, e.Message, strings.Join(e.Expected, ", "))
+}
+
+// Returns the error of the given query parsing, the position, the unexpected token
+// and the expected ones are taken from participle's error, the token types are
+// named by their descriptions (see qTokens). The errors of the resolved conditions
+// (e.g. unknown key) are returned as is.
+func toParseError(grQuery string, err error) *ParseError {
+	if perr, ok := err.(*ParseError); ok {
+		return perr
+	}
+	lerr, ok := err.(*lexer.Error)
+	if !ok {
+		return &ParseError{Message: err.Error()}
+	}
+	perr := &ParseError{Message: lerr.Message, Offset: lerr.Pos.Offset,
+		Line: lerr.Pos.Line, Column: lerr.Pos.Column}
+	if m := unexpectedErrorRe.FindStringSubmatch(lerr.Message); m != nil {
+		perr.Message = "unexpected token " + m[1]
+		perr.Expected = expectedTokens(m[2])
+	} else if strings.HasPrefix(lerr.Message, "unexpected token ") {
+		perr.Expected = expectedAfterQuery
+	}
+	// participle reports the end of the query at zero position
+	if lerr.Pos.Line == 0 {
+		perr.Message = "unexpected end of query"
+		perr.Offset, perr.Line, perr.Column = endPosition(grQuery)
+	}
+	return perr
+}
+
+// Returns the tokens of the given participle's expected grammar, e.g. [":", "<word>"]
+// for `":" | <ident>`, the token types are named by their descriptions (see qTokens)
+func expectedTokens(grammar string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, t := range expectedTokenRe.FindAllString(grammar, -1) {
+		if t[0] == '"' {
+			t, _ = strconv.Unquote(t)
+		} else {
+			t = tokenDesc(t[1 : len(t)-1])
+		}
+		if t != "" && !seen[t] {
+			seen[t] = true
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Returns the description of the given token type (case-insensitive), e.g. "<word>" for "ident"
+func tokenDesc(name string) string {
+	for _, t := range qTokens {
+		if strings.EqualFold(t.name, name) {
+			return t.desc
+		}
+	}
+	return "<" + name + ">"
+}
+
+// Returns the offset, line and column of the end of the given query
+func endPosition(grQuery string) (int, int, int) {
+	line, column := 1, 1
+	for _, r := range grQuery {
+		if r == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	return len(grQuery), line, column
+}
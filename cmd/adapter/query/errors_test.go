@@ -0,0 +1,181 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		grQuery string
+		want    *ParseError
+	}{
+		{name: "empty"},
+		{name: "valid", grQuery: "pod:p1 and not (container:c1 or file:\"f 1\")"},
+		{
+			name:    "typo in operator",
+			grQuery: "pod:foo andd container:bar",
+			want: &ParseError{Message: "unexpected token \"andd\"", Offset: 8, Line: 1, Column: 9,
+				Expected: []string{"AND", "OR", "<EOF>"}},
+		},
+		{
+			name:    "typo in key",
+			grQuery: "pod:foo and containr:bar",
+			want: &ParseError{Message: "unknown key \"containr\"", Offset: 12, Line: 1, Column: 13,
+				Expected: conditionKeys()},
+		},
+		{
+			name:    "unknown key",
+			grQuery: "namespace:kube-system and image:nginx",
+			want: &ParseError{Message: "unknown key \"image\"", Offset: 26, Line: 1, Column: 27,
+				Expected: conditionKeys()},
+		},
+		{
+			name:    "keyword prefixed values",
+			grQuery: "pod:in-cluster-proxy or pod:in.foo or pod:not-found",
+		},
+		{
+			name:    "keyword before colon",
+			grQuery: "pod:p1 and container:c1 or:x",
+			want: &ParseError{Message: "unexpected token \"or\"", Offset: 24, Line: 1, Column: 25,
+				Expected: []string{"AND", "OR", "<EOF>"}},
+		},
+		{
+			name:    "keyword regex",
+			grQuery: "pod:~in",
+		},
+		{
+			name:    "valid patterns",
+			grQuery: "pod:nginx-* and container:~\"a|b\" and namespace:~kube-.*",
+		},
+		{
+			name:    "untranslatable regex",
+			grQuery: "pod:~\"a+\"",
+			want: &ParseError{Message: "unsupported regular expression \"a+\": repetition of \"a\"",
+				Offset: 5, Line: 1, Column: 6},
+		},
+		{
+			name:    "invalid glob",
+			grQuery: "pod:a[b",
+			want: &ParseError{Message: "invalid glob pattern \"a[b\": syntax error in pattern",
+				Offset: 4, Line: 1, Column: 5},
+		},
+		{
+			name:    "regex of time predicate",
+			grQuery: "since:~1h",
+			want:    &ParseError{Message: "pattern value of time predicate \"since\"", Offset: 7, Line: 1, Column: 8},
+		},
+		{
+			name:    "valid lists",
+			grQuery: "pod in (p1, \"p 2\", nginx-*) and (container not in (c1) or since:1h)",
+		},
+		{
+			name:    "empty list",
+			grQuery: "pod in ()",
+			want: &ParseError{Message: "unexpected token \")\"", Offset: 8, Line: 1, Column: 9,
+				Expected: []string{"<glob>", "<string>", "<word>", "<timestamp>", "<keyword>"}},
+		},
+		{
+			name:    "unclosed list",
+			grQuery: "(pod not in (p1, p2)",
+			want: &ParseError{Message: "unexpected end of query", Offset: 20, Line: 1, Column: 21,
+				Expected: []string{")"}},
+		},
+		{
+			name:    "missing in",
+			grQuery: "pod not (p1)",
+			want: &ParseError{Message: "unexpected token \"(\"", Offset: 8, Line: 1, Column: 9,
+				Expected: []string{"IN"}},
+		},
+		{
+			name:    "list of time predicate",
+			grQuery: "since in (1h)",
+			want:    &ParseError{Message: "list value of time predicate \"since\"", Offset: 6, Line: 1, Column: 7},
+		},
+		{
+			name:    "missing colon",
+			grQuery: "pod p1",
+			want: &ParseError{Message: "unexpected token \"p1\"", Offset: 4, Line: 1, Column: 5,
+				Expected: []string{"AND", "OR", "<EOF>"}},
+		},
+		{
+			name:    "text terms",
+			grQuery: "pod:p1 and (\"connection refused\" or not timeout) and pod",
+		},
+		{
+			name:    "missing value",
+			grQuery: "pod:",
+			want: &ParseError{Message: "unexpected end of query", Offset: 4, Line: 1, Column: 5,
+				Expected: []string{"<glob>", "<string>", "<word>", "<timestamp>", "<keyword>"}},
+		},
+		{
+			name:    "unclosed paren",
+			grQuery: "(pod:p1 or pod:p2",
+			want: &ParseError{Message: "unexpected end of query", Offset: 17, Line: 1, Column: 18,
+				Expected: []string{")"}},
+		},
+		{
+			name:    "extra paren",
+			grQuery: "pod:p1)",
+			want: &ParseError{Message: "unexpected token \")\"", Offset: 6, Line: 1, Column: 7,
+				Expected: []string{"AND", "OR", "<EOF>"}},
+		},
+		{
+			name:    "double not",
+			grQuery: "not not pod:p1",
+			want:    &ParseError{Message: "unexpected token \"not\"", Offset: 4, Line: 1, Column: 5, Expected: []string{"<word>", "<string>", "<timestamp>", "("}},
+		},
+		{
+			name:    "invalid character",
+			grQuery: "pod:p1 !",
+			want:    &ParseError{Message: "invalid token '!'", Offset: 7, Line: 1, Column: 8},
+		},
+		{
+			name:    "invalid time value",
+			grQuery: "pod:p1 and since:yesterday",
+			want: &ParseError{Message: "invalid time value \"yesterday\" is neither RFC3339 timestamp nor positive duration",
+				Offset: 17, Line: 1, Column: 18},
+		},
+		{
+			name:    "out of range time value",
+			grQuery: "before:1000-01-01T00:00:00Z",
+			want: &ParseError{Message: "invalid time value \"1000-01-01T00:00:00Z\" is out of range " +
+				"[1677-09-21T00:12:43Z, 2262-04-11T23:47:16Z]",
+				Offset: 7, Line: 1, Column: 8},
+		},
+		{
+			name:    "valid time predicates",
+			grQuery: "since:30m and not before:2019-01-01T09:00:00Z",
+		},
+		{
+			name:    "multiline",
+			grQuery: "pod:p1 and\ncontainer:",
+			want: &ParseError{Message: "unexpected end of query", Offset: 21, Line: 2, Column: 11,
+				Expected: []string{"<glob>", "<string>", "<word>", "<timestamp>", "<keyword>"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Validate(tt.grQuery); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"strings"
+)
+
+type (
+	// Explains how 'Gravity log query' is translated to LQL query
+	Explanation struct {
+		// The given 'Gravity log query'
+		Query string `json:"query"`
+		// Parsed query, nil if the query is empty or it's used as literal text
+		Ast *Node `json:"ast,omitempty"`
+		// The resulting LQL query
+		Lql string `json:"lql"`
+		// Whether the query is used as literal text (see BuildLqlQuery)
+		Fallback bool `json:"fallback"`
+		// Why the query is used as literal text, nil if it's not
+		FallbackReason *ParseError `json:"fallbackReason,omitempty"`
+	}
+
+	// Represents node of parsed 'Gravity log query'
+	Node struct {
+		// Node type, one of "or", "and", "not", "condition" or "text"
+		Type string `json:"type"`
+		// Condition key (e.g. "pod"), set for "condition" node only
+		Key string `json:"key,omitempty"`
+		// Condition value or the text, set for "condition" and "text" nodes only
+		Value string `json:"value,omitempty"`
+		// Condition values of "in" condition (e.g. "pod in (p1, p2)"),
+		// set for "condition" node only
+		Values []string `json:"values,omitempty"`
+		// Whether the text is matched case-sensitively, set for "text" node only
+		CaseSensitive bool `json:"caseSensitive,omitempty"`
+		// Kind of the condition value pattern, "glob" or "regex",
+		// empty for the exact value
+		Pattern string `json:"pattern,omitempty"`
+		// Logrange field the condition is applied to ("ts" for time predicates,
+		// "msg" for text), set for "condition" and "text" nodes only
+		Field string `json:"field,omitempty"`
+		// Operands of "or", "and" and "not" nodes
+		Children []*Node `json:"children,omitempty"`
+	}
+
+	// Represents 'Gravity log query' parsing error
+	ParseError struct {
+		Message string `json:"message"`
+		// Position of the error in the query, the offset is 0-based,
+		// line and column are 1-based (0 if the position is unknown)
+		Offset int `json:"offset"`
+		Line   int `json:"line"`
+		Column int `json:"column"`
+		// Tokens expected at the error position, e.g. "AND", "<value>", "<EOF>"
+		Expected []string `json:"expected,omitempty"`
+	}
+)
+
+const (
+	// Node types
+	NodeOr        = "or"
+	NodeAnd       = "and"
+	NodeNot       = "not"
+	NodeCondition = "condition"
+	NodeText      = "text"
+)
+
+// Explain explains how the given 'Gravity log query' is translated to LQL
+// query for the given params (see BuildLqlQuery)
+func Explain(grQuery string, opts Options, partition string, tr TimeRange, limit int, offset int) *Explanation {
+	e := &Explanation{Query: grQuery, Lql: BuildLqlQuery(grQuery, opts, partition, tr, limit, offset)}
+	if grQuery == "" {
+		return e
+	}
+
+	q, err := parseGravityQuery(grQuery)
+	if err != nil {
+		e.Fallback = true
+		e.FallbackReason = toParseError(grQuery, err)
+		return e
+	}
+	e.Ast = expressionNode(q.Exp, opts)
+	return e
+}
+
+// The single operand "or" and "and" nodes are omitted in the resulting tree
+func expressionNode(exp *expression, opts Options) *Node {
+	n := &Node{Type: NodeOr}
+	for _, c := range exp.Or {
+		n.Children = append(n.Children, orConditionNode(c, opts))
+	}
+	if len(n.Children) == 1 {
+		return n.Children[0]
+	}
+	return n
+}
+
+func orConditionNode(c *orCondition, opts Options) *Node {
+	n := &Node{Type: NodeAnd}
+	for _, x := range c.And {
+		n.Children = append(n.Children, xConditionNode(x, opts))
+	}
+	if len(n.Children) == 1 {
+		return n.Children[0]
+	}
+	return n
+}
+
+func xConditionNode(x *xCondition, opts Options) *Node {
+	var n *Node
+	if x.Expr != nil {
+		n = expressionNode(x.Expr, opts)
+	} else if x.Text != nil {
+		n = &Node{Type: NodeText, Value: x.Text.Value, CaseSensitive: opts.CaseSensitive, Field: "msg"}
+	} else {
+		n = &Node{Type: NodeCondition, Key: strings.ToLower(x.Cond.Key),
+			Value: x.Cond.value(), Pattern: x.Cond.patternKind(), Field: x.Cond.field}
+		if timeKeyToOp[strings.ToLower(x.Cond.Key)] != "" {
+			n.Field = "ts"
+		}
+		if l := x.Cond.List; l != nil {
+			n.Values = l.values()
+			if l.Not {
+				n = &Node{Type: NodeNot, Children: []*Node{n}}
+			}
+		}
+	}
+	if x.Not {
+		return &Node{Type: NodeNot, Children: []*Node{n}}
+	}
+	return n
+}
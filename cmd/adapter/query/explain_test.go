@@ -0,0 +1,139 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_Explain(t *testing.T) {
+	tests := []struct {
+		name    string
+		grQuery string
+		opts    Options
+		want    *Explanation
+	}{
+		{
+			name: "empty",
+			want: &Explanation{Lql: "SELECT FROM p LIMIT 10"},
+		},
+		{
+			name:    "condition",
+			grQuery: "pod:p1",
+			want: &Explanation{
+				Query: "pod:p1",
+				Ast:   &Node{Type: NodeCondition, Key: "pod", Value: "p1", Field: "pod"},
+				Lql:   "SELECT FROM p WHERE fields:pod=\"p1\" LIMIT 10",
+			},
+		},
+		{
+			name:    "nested",
+			grQuery: "pod:p1 and not (container:c1 or container:\"c 2\")",
+			want: &Explanation{
+				Query: "pod:p1 and not (container:c1 or container:\"c 2\")",
+				Ast: &Node{Type: NodeAnd, Children: []*Node{
+					{Type: NodeCondition, Key: "pod", Value: "p1", Field: "pod"},
+					{Type: NodeNot, Children: []*Node{
+						{Type: NodeOr, Children: []*Node{
+							{Type: NodeCondition, Key: "container", Value: "c1", Field: "cname"},
+							{Type: NodeCondition, Key: "container", Value: "c 2", Field: "cname"},
+						}},
+					}},
+				}},
+				Lql: "SELECT FROM p WHERE (fields:pod=\"p1\" AND NOT (fields:cname=\"c1\" OR fields:cname=\"c 2\")) LIMIT 10",
+			},
+		},
+		{
+			name:    "time predicate",
+			grQuery: "after:2019-01-01T09:00:00Z",
+			want: &Explanation{
+				Query: "after:2019-01-01T09:00:00Z",
+				Ast:   &Node{Type: NodeCondition, Key: "after", Value: "2019-01-01T09:00:00Z", Field: "ts"},
+				Lql:   "SELECT FROM p WHERE ts > \"1546333200000000000\" LIMIT 10",
+			},
+		},
+		{
+			name:    "patterns",
+			grQuery: "pod:nginx-* or pod:~\"core.*\"",
+			want: &Explanation{
+				Query: "pod:nginx-* or pod:~\"core.*\"",
+				Ast: &Node{Type: NodeOr, Children: []*Node{
+					{Type: NodeCondition, Key: "pod", Value: "nginx-*", Pattern: "glob", Field: "pod"},
+					{Type: NodeCondition, Key: "pod", Value: "core.*", Pattern: "regex", Field: "pod"},
+				}},
+				Lql: "SELECT FROM p WHERE (fields:pod PREFIX \"nginx-\" OR fields:pod PREFIX \"core\") LIMIT 10",
+			},
+		},
+		{
+			name:    "text",
+			grQuery: "pod:p1 and \"Not Found\"",
+			opts:    Options{CaseSensitive: true},
+			want: &Explanation{
+				Query: "pod:p1 and \"Not Found\"",
+				Ast: &Node{Type: NodeAnd, Children: []*Node{
+					{Type: NodeCondition, Key: "pod", Value: "p1", Field: "pod"},
+					{Type: NodeText, Value: "Not Found", CaseSensitive: true, Field: "msg"},
+				}},
+				Lql: "SELECT FROM p WHERE (fields:pod=\"p1\" AND msg CONTAINS \"Not Found\") LIMIT 10",
+			},
+		},
+		{
+			name:    "list",
+			grQuery: "pod not in (p1, p2)",
+			want: &Explanation{
+				Query: "pod not in (p1, p2)",
+				Ast: &Node{Type: NodeNot, Children: []*Node{
+					{Type: NodeCondition, Key: "pod", Values: []string{"p1", "p2"}, Field: "pod"},
+				}},
+				Lql: "SELECT FROM p WHERE NOT (fields:pod=\"p1\" OR fields:pod=\"p2\") LIMIT 10",
+			},
+		},
+		{
+			name:    "literal fallback",
+			grQuery: "pod:p1 and",
+			want: &Explanation{
+				Query:    "pod:p1 and",
+				Lql:      "SELECT FROM p WHERE lower(msg) CONTAINS \"pod:p1 and\" LIMIT 10",
+				Fallback: true,
+				FallbackReason: &ParseError{Message: "unexpected end of query", Offset: 10, Line: 1, Column: 11,
+					Expected: []string{"<word>", "<string>", "<timestamp>", "("}},
+			},
+		},
+		{
+			name:    "literal text",
+			grQuery: "hello world",
+			want: &Explanation{
+				Query:    "hello world",
+				Lql:      "SELECT FROM p WHERE lower(msg) CONTAINS \"hello world\" LIMIT 10",
+				Fallback: true,
+				FallbackReason: &ParseError{Message: "unexpected token \"world\"", Offset: 6, Line: 1, Column: 7,
+					Expected: []string{"AND", "OR", "<EOF>"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Explain(tt.grQuery, tt.opts, "p", TimeRange{}, 10, 0); !reflect.DeepEqual(got, tt.want) {
+				gotJson, _ := json.Marshal(got)
+				wantJson, _ := json.Marshal(tt.want)
+				t.Errorf("Explain() = %s, want %s", gotJson, wantJson)
+			}
+		})
+	}
+}
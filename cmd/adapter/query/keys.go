@@ -0,0 +1,172 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Prefix of the 'Gravity log query' key which refers
+	// to the Logrange field directly, e.g. "field.ns"
+	fieldKeyPrefix = "field."
+
+	// The key of file condition, besides the mapped field it matches
+	// the log files (not the containers ones) by the field below
+	fileKey   = "file"
+	fileField = "file"
+)
+
+var (
+	// Default mapping of 'Gravity log query' keys to 'Logrange fields'.
+	// In Logrange database the 'fields' are attached to each log entry by lr-collector
+	// and contain such data as pod name, container name etc. The 'fields' are used in
+	// LQL (Logrange Query Language) queries.
+	defaultKeyToField = map[string]string{
+		"pod":       "pod",
+		"container": "cname",
+		"file":      "cid",
+		"namespace": "ns",
+		"node":      "node",
+		"level":     "level",
+	}
+
+	// Current mapping of the (lower-cased) keys to fields, see SetKeyToField()
+	keyToField     = copyKeyToField(defaultKeyToField)
+	keyToFieldLock sync.RWMutex
+
+	// Valid 'Gravity log query' key and Logrange field names
+	keyRe   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_\-]*$`)
+	fieldRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_\.\-]*$`)
+)
+
+// CheckKeyToField checks the given mapping of 'Gravity log query' keys
+// to Logrange fields, see SetKeyToField()
+func CheckKeyToField(m map[string]string) error {
+	for k, f := range m {
+		if !keyRe.MatchString(k) {
+			return fmt.Errorf("invalid key %q: must match %v", k, keyRe)
+		}
+		if isReservedKey(k) {
+			return fmt.Errorf("invalid key %q: reserved word", k)
+		}
+		if f != "" && !fieldRe.MatchString(f) {
+			return fmt.Errorf("invalid field %q of key %q: must match %v", f, k, fieldRe)
+		}
+	}
+	return nil
+}
+
+// SetKeyToField sets the mapping of 'Gravity log query' keys (case-insensitive)
+// to Logrange fields. The given entries are merged with the default ones,
+// the entry with empty field removes the key.
+func SetKeyToField(m map[string]string) error {
+	if err := CheckKeyToField(m); err != nil {
+		return err
+	}
+	res := copyKeyToField(defaultKeyToField)
+	for k, f := range m {
+		if f == "" {
+			delete(res, strings.ToLower(k))
+		} else {
+			res[strings.ToLower(k)] = f
+		}
+	}
+
+	keyToFieldLock.Lock()
+	keyToField = res
+	keyToFieldLock.Unlock()
+	return nil
+}
+
+// Returns Logrange field name for the given 'Gravity log query' key
+// (e.g. "pod", "container"), empty string is returned for unknown key
+func KeyToField(key string) string {
+	keyToFieldLock.RLock()
+	defer keyToFieldLock.RUnlock()
+	return keyToField[strings.ToLower(key)]
+}
+
+// Returns 'Gravity log query' key (lower-cased, e.g. "container") for the given
+// Logrange field name, empty string is returned if the field has no key
+func FieldToKey(field string) string {
+	keyToFieldLock.RLock()
+	defer keyToFieldLock.RUnlock()
+	var res string
+	for k, f := range keyToField {
+		if f == field && (res == "" || k < res) { // several keys may refer the same field
+			res = k
+		}
+	}
+	return res
+}
+
+// Returns Logrange field name for the given condition key, which is either
+// mapped key or "field.<name>", empty string is returned for unknown key
+func resolveKey(key string) string {
+	if strings.HasPrefix(strings.ToLower(key), fieldKeyPrefix) {
+		if f := key[len(fieldKeyPrefix):]; fieldRe.MatchString(f) {
+			return f
+		}
+		return ""
+	}
+	return KeyToField(key)
+}
+
+// Returns the sorted condition keys, e.g. "pod", "since"
+func conditionKeys() []string {
+	keyToFieldLock.RLock()
+	res := make([]string, 0, len(keyToField)+len(timeKeyToOp)+1)
+	for k := range keyToField {
+		res = append(res, k)
+	}
+	keyToFieldLock.RUnlock()
+
+	for k := range timeKeyToOp {
+		res = append(res, k)
+	}
+	res = append(res, fieldKeyPrefix+"<name>")
+	sort.Strings(res)
+	return res
+}
+
+// Tells whether the given key can't be mapped to field,
+// since it's the part of the query syntax
+func isReservedKey(k string) bool {
+	return isKeyword(k) || timeKeyToOp[strings.ToLower(k)] != ""
+}
+
+// Tells whether the given word is 'Gravity log query' keyword (case-insensitive)
+func isKeyword(w string) bool {
+	switch strings.ToLower(w) {
+	case "and", "or", "not", "in":
+		return true
+	}
+	return false
+}
+
+func copyKeyToField(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for k, f := range m {
+		res[k] = f
+	}
+	return res
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetKeyToField(t *testing.T) {
+	defer SetKeyToField(nil)
+
+	tests := []struct {
+		name    string
+		m       map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "defaults ok",
+			want: defaultKeyToField,
+		},
+		{
+			name: "merge ok",
+			m:    map[string]string{"Node": "host", "app": "app", "level": ""},
+			want: map[string]string{"pod": "pod", "container": "cname", "file": "cid",
+				"namespace": "ns", "node": "host", "app": "app"},
+		},
+		{
+			name:    "invalid key err",
+			m:       map[string]string{"field.x": "x"},
+			wantErr: true,
+		},
+		{
+			name:    "reserved key err",
+			m:       map[string]string{"Since": "ts"},
+			wantErr: true,
+		},
+		{
+			name:    "reserved operator key err",
+			m:       map[string]string{"in": "in"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid field err",
+			m:       map[string]string{"app": "app name"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetKeyToField(nil)
+			err := SetKeyToField(tt.m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetKeyToField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(keyToField, tt.want) {
+				t.Errorf("SetKeyToField() = %v, want %v", keyToField, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetKeyToField_query(t *testing.T) {
+	defer SetKeyToField(nil)
+	if err := SetKeyToField(map[string]string{"app": "app", "pod": ""}); err != nil {
+		t.Fatalf("SetKeyToField() error = %v", err)
+	}
+
+	got := BuildLqlQuery("APP:web or pod:web", Options{}, "logrange.pipe=__default__", TimeRange{}, 0, 0)
+	want := "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"app:web or pod:web\""
+	if got != want {
+		t.Errorf("BuildLqlQuery() = %v, want %v", got, want)
+	}
+	got = BuildLqlQuery("APP:web or field.pod:web", Options{}, "logrange.pipe=__default__", TimeRange{}, 0, 0)
+	want = "SELECT FROM logrange.pipe=__default__ WHERE (fields:app=\"web\" OR fields:pod=\"web\")"
+	if got != want {
+		t.Errorf("BuildLqlQuery() = %v, want %v", got, want)
+	}
+}
+
+func Test_resolveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "Container", want: "cname"},
+		{key: "namespace", want: "ns"},
+		{key: "field.stream", want: "stream"},
+		{key: "FIELD.k8s.app", want: "k8s.app"},
+		{key: "field.", want: ""},
+		{key: "field.-x", want: ""},
+		{key: "since", want: ""},
+		{key: "image", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := resolveKey(tt.key); got != tt.want {
+				t.Errorf("resolveKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
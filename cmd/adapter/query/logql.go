@@ -0,0 +1,184 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/participle"
+	"github.com/alecthomas/participle/lexer"
+)
+
+type (
+	// Represents parsed LogQL (Loki query language) log query, only the subset is supported:
+	// stream selector (e.g. `{pod="nginx", ns=~"kube-.*"}`) followed by line filters (e.g. `|= "error"`)
+	logQuery struct {
+		Matchers []*labelMatcher `parser:"\"{\" [ @@ { \",\" @@ } ] \"}\""`
+		Filters  []*lineFilter   `parser:"{ @@ }"`
+	}
+
+	// Stream selector label matcher, the label is the Logrange field name
+	labelMatcher struct {
+		Label string `parser:"@Ident"`
+		Op    string `parser:"@(\"=~\" | \"!~\" | \"!=\" | \"=\")"`
+		Value string `parser:"@(String | RawString)"`
+	}
+
+	// Line filter, the text and the regular expression are case-sensitive
+	lineFilter struct {
+		Op    string `parser:"@(\"|=\" | \"!=\" | \"|~\" | \"!~\")"`
+		Value string `parser:"@(String | RawString)"`
+	}
+)
+
+var (
+	// LogQL lexer
+	logqlLexer = lexer.Must(lexer.Regexp(`(\s+)` +
+		`|(?P<Ident>[a-zA-Z_][a-zA-Z0-9_\.\-]*)` +
+		"|(?P<Operator>\\|=|\\|~|=~|!=|!~|=|[{},])" +
+		`|(?P<String>"([^\\"]|\\.)*")` +
+		"|(?P<RawString>`[^`]*`)",
+	))
+
+	// LogQL parser
+	logqlParser = participle.MustBuild(&logQuery{},
+		participle.Lexer(logqlLexer),
+		participle.Unquote("String"),
+		participle.Map(func(t lexer.Token) (lexer.Token, error) {
+			t.Value = t.Value[1 : len(t.Value)-1]
+			return t, nil
+		}, "RawString"))
+)
+
+// The function is used to build LQL (Logrange Query Language) query by the given
+// LogQL (Loki query language) log query, see logQuery for the supported subset.
+// The stream selector labels are the Logrange field names (e.g. "cname", not "container").
+//
+// Unlike BuildLqlQuery(), it returns error if the query is invalid or unsupported
+// (e.g. metric query or parser expression), there is no literal text search fallback.
+func BuildLqlQueryFromLogQL(logQL string, partition string, tr TimeRange, limit int, offset int) (string, error) {
+	q := &logQuery{}
+	err := logqlParser.ParseString(logQL, q)
+	cond := ""
+	if err == nil {
+		cond, err = buildLogQLLql(q)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid or unsupported LogQL query %q: %v", logQL, err)
+	}
+	return buildLqlQuery(cond, partition, tr, limit, offset), nil
+}
+
+// Translates LogQL log query to LQL condition, the label matchers
+// and line filters are combined by 'AND'
+func buildLogQLLql(q *logQuery) (string, error) {
+	var conds []string
+	for _, m := range q.Matchers {
+		ps := []pattern{{{lit: m.Value}}}
+		if m.Op == "=~" || m.Op == "!~" {
+			var err error
+			if ps, err = parseRegex(m.Value); err != nil {
+				return "", err
+			}
+		}
+		conds = append(conds, negateLql(m.Op[0] == '!', patternsLql(m.Label, ps)))
+	}
+	for _, f := range q.Filters {
+		cond := buildTextLql(f.Value, Options{CaseSensitive: true})
+		if f.Op == "|~" || f.Op == "!~" {
+			var err error
+			if cond, err = buildTextRegexLql(f.Value); err != nil {
+				return "", err
+			}
+		}
+		conds = append(conds, negateLql(f.Op[0] == '!', cond))
+	}
+
+	var lql bytes.Buffer
+	if len(conds) > 1 {
+		lql.WriteString("(")
+	}
+	lql.WriteString(strings.Join(conds, " AND "))
+	if len(conds) > 1 {
+		lql.WriteString(")")
+	}
+	return lql.String(), nil
+}
+
+// Translates line filter regular expression, which matches any part of the message,
+// to LQL condition. The case-insensitive expression (e.g. "(?i)error") is matched against
+// the lower-cased message. Returns error if the expression needs the LQL 'LIKE' wildcards
+// (e.g. "a.*b"), see regexToPatterns()
+func buildTextRegexLql(v string) (string, error) {
+	re, err := syntax.Parse(v, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regular expression %q: %v", v, err)
+	}
+	re = re.Simplify()
+	operand := "msg"
+	// the flags can't be changed in the middle, so all the literals are case-insensitive
+	if strings.HasPrefix(v, "(?i)") && !strings.Contains(v[4:], "(?") {
+		lowerLiterals(re)
+		operand = "lower(msg)"
+	}
+	ps, err := regexToPatterns(v, re, false)
+	if err != nil {
+		return "", err
+	}
+
+	conds := make([]string, 0, len(ps))
+	for _, p := range ps {
+		op, pv := p.op()
+		switch {
+		case len(p) == 1 && p[0].wc == "*":
+			op, pv = "CONTAINS", ""
+		case op == "=": // not supported for the message, the literal pattern is exact match
+			op, pv = "LIKE", p.String()
+		}
+		conds = append(conds, fmt.Sprintf("%v %v \"%v\"", operand, op, escaper.Replace(pv)))
+	}
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", nil
+}
+
+// Lower-cases the case-insensitive literals of the regular expression,
+// so that it matches the lower-cased text the same way
+func lowerLiterals(re *syntax.Regexp) {
+	if re.Op == syntax.OpLiteral && re.Flags&syntax.FoldCase != 0 {
+		for i, r := range re.Rune {
+			re.Rune[i] = unicode.ToLower(r)
+		}
+		re.Flags &^= syntax.FoldCase
+	}
+	for _, sub := range re.Sub {
+		lowerLiterals(sub)
+	}
+}
+
+// Returns negated LQL condition if neg is true, the condition as is otherwise
+func negateLql(neg bool, cond string) string {
+	if neg {
+		return "NOT " + cond
+	}
+	return cond
+}
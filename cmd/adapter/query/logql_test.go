@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/logrange/logrange/pkg/model/field"
+)
+
+func Test_BuildLqlQueryFromLogQL(t *testing.T) {
+	tr := TimeRange{Since: time.Unix(0, 100), Until: time.Unix(0, 200)}
+	tests := []struct {
+		logQL   string
+		tr      TimeRange
+		want    string
+		wantErr bool
+	}{
+		{
+			logQL: "{}",
+			want:  "SELECT FROM logrange.pipe=__default__ LIMIT 100",
+		},
+		{
+			logQL: "{pod=\"nginx-1\"}",
+			tr:    tr,
+			want:  "SELECT FROM logrange.pipe=__default__ WHERE fields:pod=\"nginx-1\" AND ts >= \"100\" AND ts <= \"200\" LIMIT 100",
+		},
+		{
+			logQL: "{pod!=\"p\\\"1\", ns=~\"kube-.*|default\", cname!~`web-\\d`}",
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (NOT fields:pod=\"p\\\"1\" AND (fields:ns PREFIX \"kube-\" OR fields:ns=\"default\") AND " +
+				"NOT fields:cname LIKE \"web-[0-9]\") LIMIT 100",
+		},
+		{
+			logQL: "{pod=\"p1\"} |= \"Error\" != `c:\\temp` |~ \"^GET |timeout$\" !~ `(?i)debug|\\*\\.go`",
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:pod=\"p1\" AND msg CONTAINS \"Error\" AND NOT msg CONTAINS \"c:\\\\temp\" AND " +
+				"(msg PREFIX \"GET \" OR msg SUFFIX \"timeout\") AND " +
+				"NOT (lower(msg) CONTAINS \"debug\" OR lower(msg) CONTAINS \"*.go\")) LIMIT 100",
+		},
+		{
+			logQL: "{} |~ \"^[eE]rror$|^$\" |~ \".*\"",
+			want:  "SELECT FROM logrange.pipe=__default__ WHERE ((msg LIKE \"[Ee]rror\" OR msg LIKE \"\") AND msg CONTAINS \"\") LIMIT 100",
+		},
+		{
+			logQL: "{k8s.pod-name=\"p1\"} |~ \"\"",
+			want:  "SELECT FROM logrange.pipe=__default__ WHERE (fields:k8s.pod-name=\"p1\" AND msg CONTAINS \"\") LIMIT 100",
+		},
+		{logQL: "", wantErr: true},
+		{logQL: "pod:p1", wantErr: true},
+		{logQL: "{pod=p1}", wantErr: true},
+		{logQL: "{pod=\"p1\"} | json", wantErr: true},
+		{logQL: "count_over_time({pod=\"p1\"}[5m])", wantErr: true},
+		{logQL: "{pod=~\"p+\"}", wantErr: true},
+		{logQL: "{pod=\"p1\"} |~ \"(\"", wantErr: true},
+		{logQL: "{} |~ \"GET .*timeout\"", wantErr: true},
+		{logQL: "{} |~ \"[eE]rror\"", wantErr: true},
+		{logQL: "{} |~ \"(?i)get(?-i)X\"", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.logQL, func(t *testing.T) {
+			got, err := BuildLqlQueryFromLogQL(tt.logQL, "logrange.pipe=__default__", tt.tr, 100, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildLqlQueryFromLogQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BuildLqlQueryFromLogQL() = %v, want %v", got, tt.want)
+			}
+			if _, err := lql.ParseLql(got); err != nil && !tt.wantErr {
+				t.Errorf("BuildLqlQueryFromLogQL() = %v, err = %v", got, err)
+			}
+		})
+	}
+}
+
+// Compares the results of the translated LQL query evaluated by Logrange
+// with the ones of the line filters evaluated by Go regexp, as Loki does
+func Test_BuildLqlQueryFromLogQL_lineFilters(t *testing.T) {
+	msgs := []string{"", "GET /index timeout", "get /Index TIMEOUT", "POST /index", "error: \"x\\y\"", "Error", "line\twith tab"}
+	filters := []struct {
+		op string
+		v  string
+	}{
+		{"|=", "index"}, {"|=", "Index"}, {"!=", "GET"}, {"|=", "\"x\\y\""},
+		{"|~", "(?i)^get"}, {"|~", "index (timeout|TIMEOUT)$"}, {"!~", "(?i)error|post"}, {"|~", "^$"},
+		{"|~", "^[eE]rror$"}, {"|~", "(?i)^error: \"x\\\\y\"$|tab$"},
+	}
+	for _, f := range filters {
+		logQL := "{} " + f.op + " `" + f.v + "`"
+		l, err := BuildLqlQueryFromLogQL(logQL, "logrange.pipe=__default__", TimeRange{}, 0, 0)
+		if err != nil {
+			t.Fatalf("BuildLqlQueryFromLogQL(%q) error = %v", logQL, err)
+		}
+		q, err := lql.ParseLql(l)
+		if err != nil {
+			t.Fatalf("BuildLqlQueryFromLogQL(%q) = %v, err = %v", logQL, l, err)
+		}
+		wef, err := lql.BuildWhereExpFuncByExpression(q.Select.Where)
+		if err != nil {
+			t.Fatalf("BuildLqlQueryFromLogQL(%q) = %v, err = %v", logQL, l, err)
+		}
+
+		for _, msg := range msgs {
+			var want bool
+			if f.op[1] == '~' {
+				want = regexp.MustCompile(f.v).MatchString(msg)
+			} else {
+				want = strings.Contains(msg, f.v)
+			}
+			want = want != (f.op[0] == '!')
+			if got := wef(&model.LogEvent{Msg: []byte(msg), Fields: field.Fields("")}); got != want {
+				t.Errorf("BuildLqlQueryFromLogQL(%q) = %v matches %q = %v, want %v", logQL, l, msg, got, want)
+			}
+		}
+	}
+}
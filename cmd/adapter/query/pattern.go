@@ -0,0 +1,367 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"path"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+type (
+	// Part of the value pattern, either literal text or wildcard
+	patternPart struct {
+		// Literal text
+		lit string
+		// Wildcard: "*", "?" or character class (e.g. "[a-z]"),
+		// the anchors "^" and "$" are used while translating regular expression
+		wc string
+	}
+
+	// Value pattern, the value matches if it matches all the parts in order,
+	// the "*" and "?" wildcards match any characters including '/'
+	pattern []patternPart
+)
+
+const (
+	// Max number of the alternatives a regular expression is expanded to,
+	// e.g. "(a|b)-(c|d)" is expanded to 4 alternatives
+	regexAlternativesMax = 32
+
+	// The kinds of the condition value patterns
+	patternGlob  = "glob"
+	patternRegex = "regex"
+)
+
+var (
+	globEscaper  = strings.NewReplacer("\\", "\\\\", "*", "\\*", "?", "\\?", "[", "\\[")
+	classEscaper = strings.NewReplacer("\\", "\\\\", "]", "\\]", "-", "\\-", "^", "\\^")
+)
+
+// Parses glob pattern (e.g. "nginx-*"), which consists of literal text
+// and '*', '?' and '[...]' wildcards. Returns error if the pattern can't be
+// translated to LQL exactly (see pattern.checkWildcards())
+func parseGlob(v string) (pattern, error) {
+	if _, err := path.Match(v, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %v", v, err)
+	}
+	var p pattern
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '*', '?':
+			p = p.add(patternPart{wc: v[i : i+1]})
+		case '[':
+			j := i + 1
+			if v[j] == '^' {
+				j++
+			}
+			j += strings.IndexByte(v[j:], ']')
+			p = p.add(patternPart{wc: v[i : j+1]})
+			i = j
+		default:
+			p = p.add(patternPart{lit: v[i : i+1]})
+		}
+	}
+	if err := p.checkWildcards(); err != nil {
+		return nil, fmt.Errorf("unsupported glob pattern %q: %v", v, err)
+	}
+	return p, nil
+}
+
+// Parses regular expression (e.g. "nginx-.*"), which must match the whole value,
+// and translates it to the patterns, the value matches if it matches any of them.
+// Returns error if the expression can't be expressed by the patterns exactly.
+func parseRegex(v string) ([]pattern, error) {
+	re, err := syntax.Parse(v, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", v, err)
+	}
+	return regexToPatterns(v, re.Simplify(), true)
+}
+
+// Translates the parsed regular expression v to the patterns, if it's not anchored
+// it matches any part of the value (e.g. "err" matches "an error"),
+// unless it's explicitly anchored by '^' or '$'. Returns error if the pattern
+// can't be translated to LQL exactly (see pattern.checkWildcards()).
+func regexToPatterns(v string, re *syntax.Regexp, anchored bool) ([]pattern, error) {
+	alts, err := regexPatterns(re)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported regular expression %q: %v", v, err)
+	}
+
+	res := make([]pattern, 0, len(alts))
+	for _, p := range alts {
+		if len(p) > 0 && p[0].wc == "^" {
+			p = p[1:]
+		} else if !anchored {
+			p = pattern{{wc: "*"}}.concat(p)
+		}
+		if len(p) > 0 && p[len(p)-1].wc == "$" {
+			p = p[:len(p)-1]
+		} else if !anchored {
+			p = p.add(patternPart{wc: "*"})
+		}
+		for _, pp := range p {
+			if pp.wc == "^" || pp.wc == "$" {
+				return nil, fmt.Errorf("unsupported regular expression %q: anchor in the middle", v)
+			}
+		}
+		if err := p.checkWildcards(); err != nil {
+			return nil, fmt.Errorf("unsupported regular expression %q: %v", v, err)
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// Returns the patterns (alternatives) the given regular expression matches
+func regexPatterns(re *syntax.Regexp) ([]pattern, error) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []pattern{nil}, nil
+	case syntax.OpLiteral:
+		var p pattern
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && unicode.SimpleFold(r) != r {
+				p = p.add(patternPart{wc: foldClass(r)})
+			} else {
+				p = p.add(patternPart{lit: string(r)})
+			}
+		}
+		return []pattern{p}, nil
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []pattern{{{wc: "?"}}}, nil
+	case syntax.OpCharClass:
+		c, err := charClass(re.Rune)
+		if err != nil {
+			return nil, err
+		}
+		return []pattern{{{wc: c}}}, nil
+	case syntax.OpBeginText:
+		return []pattern{{{wc: "^"}}}, nil
+	case syntax.OpEndText:
+		return []pattern{{{wc: "$"}}}, nil
+	case syntax.OpCapture:
+		return regexPatterns(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		if op := re.Sub[0].Op; op != syntax.OpAnyChar && op != syntax.OpAnyCharNotNL {
+			return nil, fmt.Errorf("repetition of %q", re.Sub[0])
+		}
+		if re.Op == syntax.OpPlus {
+			return []pattern{{{wc: "?"}, {wc: "*"}}}, nil
+		}
+		return []pattern{{{wc: "*"}}}, nil
+	case syntax.OpQuest:
+		sub, err := regexPatterns(re.Sub[0])
+		if err != nil {
+			return nil, err
+		}
+		return alternatives(append([]pattern{nil}, sub...))
+	case syntax.OpAlternate:
+		var res []pattern
+		for _, s := range re.Sub {
+			sub, err := regexPatterns(s)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sub...)
+		}
+		return alternatives(res)
+	case syntax.OpConcat:
+		res := []pattern{nil}
+		for _, s := range re.Sub {
+			sub, err := regexPatterns(s)
+			if err != nil {
+				return nil, err
+			}
+			var prod []pattern
+			for _, p := range res {
+				for _, sp := range sub {
+					prod = append(prod, p.concat(sp))
+				}
+			}
+			if res, err = alternatives(prod); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("%q", re)
+}
+
+func alternatives(res []pattern) ([]pattern, error) {
+	if len(res) > regexAlternativesMax {
+		return nil, fmt.Errorf("more than %d alternatives", regexAlternativesMax)
+	}
+	return res, nil
+}
+
+// Returns the character class (e.g. "[a-z]") for the given rune ranges,
+// the class must not contain control characters (they can't be used in LQL string)
+func charClass(ranges []rune) (string, error) {
+	neg := len(ranges) > 0 && ranges[0] == 0
+	if neg { // the complement has no control characters probably, e.g. [^a-z]
+		var compl []rune
+		next := rune(0)
+		for i := 0; i < len(ranges); i += 2 {
+			if ranges[i] > next {
+				compl = append(compl, next, ranges[i]-1)
+			}
+			next = ranges[i+1] + 1
+		}
+		if next <= unicode.MaxRune {
+			compl = append(compl, next, unicode.MaxRune)
+		}
+		ranges = compl
+	}
+
+	var sb strings.Builder
+	sb.WriteString("[")
+	if neg {
+		sb.WriteString("^")
+	}
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if !unicode.IsPrint(lo) || !unicode.IsPrint(hi) || lo < ' ' {
+			return "", fmt.Errorf("character class with non-printable characters")
+		}
+		sb.WriteString(classEscaper.Replace(string(lo)))
+		if hi != lo {
+			sb.WriteString("-")
+			sb.WriteString(classEscaper.Replace(string(hi)))
+		}
+	}
+	sb.WriteString("]")
+	return sb.String(), nil
+}
+
+// Returns the character class matching the given rune in any case, e.g. "[kKK]"
+func foldClass(r rune) string {
+	var sb strings.Builder
+	sb.WriteString("[")
+	f := r
+	for {
+		sb.WriteString(classEscaper.Replace(string(f)))
+		if f = unicode.SimpleFold(f); f == r {
+			break
+		}
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// Appends the given part to the pattern, the adjacent literals
+// and the adjacent "*" wildcards are merged
+func (p pattern) add(pp patternPart) pattern {
+	if len(p) > 0 {
+		last := &p[len(p)-1]
+		if pp.lit != "" && last.wc == "" {
+			last.lit += pp.lit
+			return p
+		}
+		if pp.wc == "*" && last.wc == "*" {
+			return p
+		}
+	}
+	return append(p, pp)
+}
+
+func (p pattern) concat(other pattern) pattern {
+	res := append(pattern{}, p...)
+	for _, pp := range other {
+		res = res.add(pp)
+	}
+	return res
+}
+
+// Returns LQL condition for the given field, which matches the pattern,
+// the most efficient operator is chosen ('=', 'PREFIX', 'SUFFIX', 'CONTAINS' or 'LIKE')
+func (p pattern) lql(field string) string {
+	op, v := p.op()
+	if op == "=" {
+		return fmt.Sprintf("fields:%v=\"%v\"", field, escaper.Replace(v))
+	}
+	return fmt.Sprintf("fields:%v %v \"%v\"", field, op, escaper.Replace(v))
+}
+
+// Returns the most efficient LQL operator matching the pattern and its (unescaped) operand
+func (p pattern) op() (string, string) {
+	isLit := func(i int) bool { return p[i].wc == "" }
+	isAny := func(i int) bool { return p[i].wc == "*" }
+
+	op, v := "LIKE", ""
+	switch {
+	case len(p) == 0:
+		op = "="
+	case len(p) == 1 && isLit(0):
+		op, v = "=", p[0].lit
+	case len(p) == 1 && isAny(0): // any value
+		op = "PREFIX"
+	case len(p) == 2 && isLit(0) && isAny(1):
+		op, v = "PREFIX", p[0].lit
+	case len(p) == 2 && isAny(0) && isLit(1):
+		op, v = "SUFFIX", p[1].lit
+	case len(p) == 3 && isAny(0) && isLit(1) && isAny(2):
+		op, v = "CONTAINS", p[1].lit
+	default:
+		v = p.String()
+	}
+	return op, v
+}
+
+// Returns error if the pattern can't be translated to LQL exactly: its "*" and "?"
+// wildcards match '/', while the LQL 'LIKE' ones don't, so they're supported only
+// if the pattern is translated to '=', 'PREFIX', 'SUFFIX' or 'CONTAINS' condition,
+// i.e. "*" at the beginning or the end of the otherwise literal text (e.g. "*.log")
+func (p pattern) checkWildcards() error {
+	if op, _ := p.op(); op != "LIKE" {
+		return nil
+	}
+	for _, pp := range p {
+		if pp.wc == "*" || pp.wc == "?" {
+			return fmt.Errorf("%q wildcard needs LQL 'LIKE', whose wildcards don't match '/'", pp.wc)
+		}
+	}
+	return nil
+}
+
+// Returns the pattern as glob, i.e. LQL 'LIKE' operand
+func (p pattern) String() string {
+	var sb strings.Builder
+	for _, pp := range p {
+		if pp.wc != "" {
+			sb.WriteString(pp.wc)
+		} else {
+			sb.WriteString(globEscaper.Replace(pp.lit))
+		}
+	}
+	return sb.String()
+}
+
+// Returns LQL condition for the given field, which matches any of the patterns
+func patternsLql(field string, ps []pattern) string {
+	if len(ps) == 1 {
+		return ps[0].lql(field)
+	}
+	conds := make([]string, 0, len(ps))
+	for _, p := range ps {
+		conds = append(conds, p.lql(field))
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
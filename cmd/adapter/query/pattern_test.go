@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/logrange/logrange/pkg/model/field"
+)
+
+func Test_parseGlob_lql(t *testing.T) {
+	tests := []struct {
+		glob    string
+		want    string
+		wantErr bool
+	}{
+		{glob: "nginx-*", want: "fields:pod PREFIX \"nginx-\""},
+		{glob: "*-abcde", want: "fields:pod SUFFIX \"-abcde\""},
+		{glob: "*nginx*", want: "fields:pod CONTAINS \"nginx\""},
+		{glob: "**nginx**", want: "fields:pod CONTAINS \"nginx\""},
+		{glob: "*/app.log", want: "fields:pod SUFFIX \"/app.log\""},
+		{glob: "/var/log/*", want: "fields:pod PREFIX \"/var/log/\""},
+		{glob: "nginx-[0-9]", want: "fields:pod LIKE \"nginx-[0-9]\""},
+		{glob: "nginx-?", wantErr: true},
+		{glob: "*/app?.log", wantErr: true},
+		{glob: "/var/log/*/app.log", wantErr: true},
+		{glob: "nginx-*-[a-f]*", wantErr: true},
+		{glob: "nginx-[^a]*", wantErr: true},
+		{glob: "nginx-[]", wantErr: true},
+		{glob: "nginx-[a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			p, err := parseGlob(tt.glob)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGlob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.lql("pod") != tt.want {
+				t.Errorf("parseGlob() = %v, want %v", p.lql("pod"), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRegex_lql(t *testing.T) {
+	tests := []struct {
+		re      string
+		want    string
+		wantErr bool
+	}{
+		{re: "nginx", want: "fields:pod=\"nginx\""},
+		{re: "^nginx-.*$", want: "fields:pod PREFIX \"nginx-\""},
+		{re: ".*-abc", want: "fields:pod SUFFIX \"-abc\""},
+		{re: ".*nginx.*", want: "fields:pod CONTAINS \"nginx\""},
+		{re: ".*", want: "fields:pod PREFIX \"\""},
+		{re: "nginx-\\d{2}", want: "fields:pod LIKE \"nginx-[0-9][0-9]\""},
+		{re: "a\\*b[.x]", want: "fields:pod LIKE \"a\\\\*b[.x]\""},
+		{re: "[^-/]x", want: "fields:pod LIKE \"[^\\\\-/]x\""},
+		{re: "(?i)ab", want: "fields:pod LIKE \"[Aa][Bb]\""},
+		{re: "nginx|coredns-.*", want: "(fields:pod=\"nginx\" OR fields:pod PREFIX \"coredns-\")"},
+		{re: "(ab|cd)-e?", want: "(fields:pod=\"ab-\" OR fields:pod=\"ab-e\" OR fields:pod=\"cd-\" OR fields:pod=\"cd-e\")"},
+		{re: "nginx-[a-f]+", wantErr: true},
+		{re: "nginx-.+", wantErr: true},
+		{re: "/var/log/.*/x.log", wantErr: true},
+		{re: "a.b", wantErr: true},
+		{re: "a\\sb", wantErr: true},
+		{re: "a^b", wantErr: true},
+		{re: "(ab|cd)(ef|gh)(ij|kl)(mn|op)(qr|st)(uv|wx)", wantErr: true},
+		{re: "a(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.re, func(t *testing.T) {
+			ps, err := parseRegex(tt.re)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRegex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := patternsLql("pod", ps)
+			if got != tt.want {
+				t.Errorf("parseRegex() = %v, want %v", got, tt.want)
+			}
+			if _, err := lql.ParseExpr(got); err != nil {
+				t.Errorf("parseRegex() = %v, err = %v", got, err)
+			}
+		})
+	}
+}
+
+// The translated regular expressions must match the same values
+// as the expressions themselves when evaluated by Logrange
+func Test_parseRegex_eval(t *testing.T) {
+	res := []string{"nginx-.*", ".*-abc", "(?i)NGINX-\\d?", "[^n]x", ".*", "nginx|coredns(-x)?",
+		".*\\..*", "(a|b)?c", ""}
+	values := []string{"", "nginx", "nginx-", "nginx-1", "Nginx-2", "nginx-7f9c8d-abc", "coredns",
+		"coredns-x", "coredns-xy", "a.b", "c", "ac", "bc", "abc", "[x]", "/x", "nginx-a/b", "a/b-abc", "a/b.c"}
+
+	for _, re := range res {
+		ps, err := parseRegex(re)
+		if err != nil {
+			t.Fatalf("parseRegex(%q) error = %v", re, err)
+		}
+		cond := patternsLql("pod", ps)
+		wef, err := lql.BuildWhereExpFunc(cond)
+		if err != nil {
+			t.Fatalf("BuildWhereExpFunc(%q) error = %v", cond, err)
+		}
+		rx := regexp.MustCompile("^(?:" + re + ")$")
+		for _, v := range values {
+			flds, _ := field.NewFields(map[string]string{"pod": v})
+			if got, want := wef(&model.LogEvent{Fields: flds}), rx.MatchString(v); got != want {
+				t.Errorf("%q (%v) matches %q = %v, want %v", re, cond, v, got, want)
+			}
+		}
+	}
+}
@@ -21,19 +21,67 @@ import (
 	"fmt"
 	"github.com/alecthomas/participle"
 	"github.com/alecthomas/participle/lexer"
+	"io"
+	"io/ioutil"
+	"math"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 type (
+	// Represents time range of the log entries to be queried,
+	// zero Since/Until means the range is not bounded from that side
+	TimeRange struct {
+		Since time.Time
+		Until time.Time
+	}
+
+	// Options of 'Gravity log query' translation
+	Options struct {
+		// Whether the text terms (and the literal text search) are case-sensitive
+		CaseSensitive bool
+	}
+
 	// Represents parsed 'Gravity log query'
 	query struct {
 		Exp *expression `parser:"@@"`
 	}
 
+	// Condition key followed by either the value or the list of values, the bare key
+	// is the text term (see resolveConditions), so that the parser needs no lookahead
+	// and reports the errors at the tokens they are caused by
 	condition struct {
-		Key   string `parser:"@(\"POD\"|\"CONTAINER\"|\"FILE\") \":\""`
-		Value string `parser:"@(String|Ident)"`
+		Pos lexer.Position
+		Key string `parser:"@Ident"`
+		// List of the values, e.g. `pod in (p1, p2)`
+		List *valueList `parser:"( @@"`
+		// Tells whether the value is regular expression, e.g. `pod:~"nginx-.*"`
+		Regex bool            `parser:"| \":\" (@\"~\")?"`
+		Val   *conditionValue `parser:"@@ )?"`
+		// Resolved Logrange field of the key (e.g. "cname" for "container")
+		field string
+		// Resolved patterns of the value (exact value is the single literal),
+		// the value matches if it matches any of them
+		patterns []pattern
+		// Resolved value of the time predicate (e.g. "since:30m")
+		ts time.Time
+	}
+
+	// The values of "in" and "not in" conditions
+	valueList struct {
+		Pos    lexer.Position
+		Not    bool              `parser:"(@\"NOT\")? \"IN\" \"(\""`
+		Values []*conditionValue `parser:"@@ { \",\" @@ } \")\""`
+	}
+
+	// The condition value, either exact one or glob pattern
+	conditionValue struct {
+		Pos lexer.Position
+		// Glob pattern value, e.g. `pod:nginx-*`
+		Glob  string `parser:"@Glob"`
+		Value string `parser:"| @(String|Ident|Time|Keyword)"`
 	}
 
 	expression struct {
@@ -47,42 +95,279 @@ type (
 	xCondition struct {
 		Not  bool        `parser:"(@\"NOT\")?"`
 		Cond *condition  `parser:"(@@"`
+		Text *textTerm   `parser:"| @@"`
 		Expr *expression `parser:"| \"(\" @@ \")\")"`
 	}
+
+	// Bare word or quoted phrase, which is searched in the log message,
+	// the bare word is parsed as the condition key (see condition)
+	textTerm struct {
+		Value string `parser:"@(String|Time)"`
+	}
+
+	// Token of 'Gravity log query' lexer
+	tokenDef struct {
+		name    string
+		pattern string
+		// Name of the token in the parse errors
+		desc string
+	}
+
+	// Lexer definition, which turns the Ident tokens, that are keywords ("AND", "OR",
+	// "NOT", "IN") followed by whitespace, "(" or the end of the query, into Keyword
+	// tokens, so the values like "in-cluster-proxy" or "not.found" stay single tokens
+	keywordLexerDef struct {
+		lexer.Definition
+		symbols map[string]rune
+	}
+
+	keywordLexer struct {
+		lexer.Lexer
+		// The query, the characters following the tokens are looked up in it
+		input string
+		// Types of Ident and Keyword tokens
+		ident, keyword rune
+	}
 )
 
 var (
+	// Gravity log query tokens, the lexer is built of them (in the order of precedence),
+	// except Keyword, which is Ident turned into it by the lexer (see keywordLexer)
+	qTokens = []tokenDef{
+		{name: "Time", pattern: `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+\-]\d{2}:\d{2})`, desc: "<timestamp>"},
+		{name: "Glob", pattern: `[a-zA-Z0-9_\.\-\[\]\^]*[\*\?\[][a-zA-Z0-9_\.\-\*\?\[\]\^]*`, desc: "<glob>"},
+		{name: "Ident", pattern: `[a-zA-Z0-9_\.][a-zA-Z0-9_\.\-]*`, desc: "<word>"},
+		{name: "Operator", pattern: `[:~(),]`},
+		{name: "String", pattern: `"([^\\"]|\\.)*"|'[^']*'`, desc: "<string>"},
+		{name: "Keyword", desc: "<keyword>"},
+	}
+
 	// Gravity log query lexer
-	qLexer = lexer.Must(lexer.Regexp(`(\s+)` +
-		`|(?P<Keyword>(?i)POD|CONTAINER|FILE|AND|OR|NOT)` +
-		`|(?P<Ident>[a-zA-Z0-9_\.][a-zA-Z0-9_\.\-]*)` +
-		`|(?P<Operator>:|[()])` +
-		`|(?P<String>"([^\\"]|\\.)*"|'[^']*')`,
-	))
-
-	// Gravity log query parser
+	qLexer = newKeywordLexerDef(lexer.Must(lexer.Regexp(tokensRegexp(qTokens))))
+
+	// Gravity log query parser, it needs no lookahead, so that the syntax errors
+	// are reported at the unexpected tokens rather than where the parser backtracked to
 	qParser = participle.MustBuild(&query{},
 		participle.Lexer(qLexer),
 		participle.Unquote("String"),
-		participle.CaseInsensitive("Keyword"))
+		participle.CaseInsensitive("Keyword"),
+		participle.UseLookahead(0))
 
-	// Map is used to map 'Gravity log terms' to 'Logrange fields'.
-	// In Logrange database the 'fields' are attached to each log entry and contain
-	// such data as pod name, container name etc. The 'fields' are used in
-	// LQL (Logrange Query Language) queries.
-	keyToField = map[string]string{
-		"POD":       "pod",
-		"CONTAINER": "cname",
-		"FILE":      "cid",
+	// Map is used to map 'Gravity log query' time predicates
+	// to the operators of LQL timestamp conditions
+	timeKeyToOp = map[string]string{
+		"since":  ">=",
+		"after":  ">",
+		"before": "<",
 	}
 
 	escaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+	// Returns the current time, the relative time predicates
+	// (e.g. "since:30m") are calculated against it
+	timeNow = time.Now
+
+	// Time values range, the times outside of it overflow Unix nanoseconds
+	MinTime = time.Unix(0, math.MinInt64).UTC()
+	MaxTime = time.Unix(0, math.MaxInt64).UTC()
 )
 
+// Returns the lexer regular expression of the given tokens, the whitespaces are skipped
+func tokensRegexp(tokens []tokenDef) string {
+	var sb strings.Builder
+	sb.WriteString(`(\s+)`)
+	for _, t := range tokens {
+		if t.pattern != "" {
+			sb.WriteString("|(?P<" + t.name + ">" + t.pattern + ")")
+		}
+	}
+	return sb.String()
+}
+
+// Returns the lexer definition, which adds Keyword tokens to the given one
+func newKeywordLexerDef(def lexer.Definition) *keywordLexerDef {
+	symbols := make(map[string]rune, len(def.Symbols())+1)
+	keyword := lexer.EOF
+	for name, t := range def.Symbols() {
+		symbols[name] = t
+		if t <= keyword {
+			keyword = t - 1
+		}
+	}
+	symbols["Keyword"] = keyword
+	return &keywordLexerDef{Definition: def, symbols: symbols}
+}
+
+func (d *keywordLexerDef) Lex(r io.Reader) (lexer.Lexer, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lex, err := d.Definition.Lex(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &keywordLexer{Lexer: lex, input: string(b), ident: d.symbols["Ident"], keyword: d.symbols["Keyword"]}, nil
+}
+
+func (d *keywordLexerDef) Symbols() map[string]rune {
+	return d.symbols
+}
+
+func (l *keywordLexer) Next() (lexer.Token, error) {
+	t, err := l.Lexer.Next()
+	if err != nil || t.Type != l.ident || !isKeyword(t.Value) {
+		return t, err
+	}
+	next := l.input[t.Pos.Offset+len(t.Value):]
+	if next == "" || next[0] == '(' || unicode.IsSpace(rune(next[0])) {
+		t.Type = l.keyword
+	}
+	return t, nil
+}
+
+// ParseTime parses time value which is either RFC3339 timestamp
+// or positive duration (e.g. "15m", "1h30m") relative to the given time,
+// the time must be in [MinTime, MaxTime] (i.e. between years 1678 and 2262)
+func ParseTime(v string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		d, errD := time.ParseDuration(v)
+		if errD != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("%q is neither RFC3339 timestamp nor positive duration", v)
+		}
+		t = now.Add(-d)
+	}
+	if t.Before(MinTime) || t.After(MaxTime) {
+		return time.Time{}, fmt.Errorf("%q is out of range [%v, %v]", v,
+			MinTime.Format(time.RFC3339), MaxTime.Format(time.RFC3339))
+	}
+	return t, nil
+}
+
 func parseGravityQuery(qs string) (*query, error) {
 	q := &query{}
-	err := qParser.ParseString(qs, q)
-	return q, err
+	if err := qParser.ParseString(qs, q); err != nil {
+		return q, err
+	}
+	return q, resolveConditions(q.Exp, timeNow())
+}
+
+// Resolves the text terms of the bare words, the fields of the condition keys
+// and the values of the time predicates (against the given time), returns
+// the error positioned at the token it's caused by (e.g. unknown key)
+func resolveConditions(exp *expression, now time.Time) error {
+	for _, or := range exp.Or {
+		for _, x := range or.And {
+			var err error
+			switch {
+			case x.Expr != nil:
+				err = resolveConditions(x.Expr, now)
+			case x.Cond != nil && x.Cond.List == nil && x.Cond.Val == nil:
+				x.Text, x.Cond = &textTerm{Value: x.Cond.Key}, nil
+			case x.Cond != nil:
+				err = x.Cond.resolve(now)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resolves the field of the condition key, the value of the time predicate
+// or the patterns of the value
+func (c *condition) resolve(now time.Time) (err error) {
+	if timeKeyToOp[strings.ToLower(c.Key)] != "" {
+		if c.List != nil {
+			return lexer.Errorf(c.List.Pos, "list value of time predicate %q", c.Key)
+		}
+		if c.Regex || c.Val.Glob != "" {
+			return lexer.Errorf(c.Val.Pos, "pattern value of time predicate %q", c.Key)
+		}
+		if c.ts, err = ParseTime(c.Val.Value, now); err != nil {
+			return lexer.Errorf(c.Val.Pos, "invalid time value %v", err)
+		}
+		return nil
+	}
+	if c.field = resolveKey(c.Key); c.field == "" {
+		return &ParseError{Message: fmt.Sprintf("unknown key %q", c.Key), Offset: c.Pos.Offset,
+			Line: c.Pos.Line, Column: c.Pos.Column, Expected: conditionKeys()}
+	}
+	switch {
+	case c.List != nil:
+		c.patterns, err = c.List.patterns()
+		return err
+	case c.Regex:
+		c.patterns, err = parseRegex(c.Val.value())
+	case c.Val.Glob != "":
+		var p pattern
+		p, err = parseGlob(c.Val.Glob)
+		c.patterns = []pattern{p}
+	default:
+		c.patterns = []pattern{{{lit: c.Val.Value}}}
+	}
+	if err != nil {
+		return lexer.Errorf(c.Val.Pos, "%v", err)
+	}
+	return nil
+}
+
+// Returns the patterns of the list values, the duplicates are omitted
+func (l *valueList) patterns() ([]pattern, error) {
+	res := make([]pattern, 0, len(l.Values))
+	seen := make(map[string]bool, len(l.Values))
+	for _, v := range l.Values {
+		p := pattern{{lit: v.Value}}
+		if v.Glob != "" {
+			var err error
+			if p, err = parseGlob(v.Glob); err != nil {
+				return nil, lexer.Errorf(v.Pos, "%v", err)
+			}
+		}
+		if !seen[p.String()] {
+			seen[p.String()] = true
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// Returns the list values as they're given in the query
+func (l *valueList) values() []string {
+	res := make([]string, 0, len(l.Values))
+	for _, v := range l.Values {
+		res = append(res, v.value())
+	}
+	return res
+}
+
+// Returns the value as it's given in the query
+func (v *conditionValue) value() string {
+	if v.Glob != "" {
+		return v.Glob
+	}
+	return v.Value
+}
+
+// Returns the condition value as it's given in the query
+func (c *condition) value() string {
+	if c.Val == nil {
+		return ""
+	}
+	return c.Val.value()
+}
+
+// Returns the kind of the condition value pattern, empty for the exact value
+func (c *condition) patternKind() string {
+	switch {
+	case c.Regex:
+		return patternRegex
+	case c.Val != nil && c.Val.Glob != "":
+		return patternGlob
+	}
+	return ""
 }
 
 // The function is used to build LQL (Logrange Query Language) query
@@ -90,37 +375,28 @@ func parseGravityQuery(qs string) (*query, error) {
 // to LQL query.
 //
 // The result is the valid LQL query which looks for matching entries
-// (for given offset and limit). If 'Gravity log query' turns out to be invalid,
+// (for given time range, offset and limit). If 'Gravity log query' turns out to be invalid,
 // it is used as literal text in LQL query.
-func BuildLqlQuery(grQuery string, partition string, limit int, offset int) string {
+func BuildLqlQuery(grQuery string, opts Options, partition string, tr TimeRange, limit int, offset int) string {
+	return buildLqlQuery(buildGravityLql(grQuery, opts), partition, tr, limit, offset)
+}
+
+// Builds LQL query by the given LQL condition (empty one matches all the entries)
+// and the rest of the params
+func buildLqlQuery(condLql string, partition string, tr TimeRange, limit int, offset int) string {
 	var lql bytes.Buffer
 	lql.WriteString("SELECT FROM ")
 	lql.WriteString(partition)
 
-	if grQuery != "" {
+	tsLql := buildTimeRangeLql(tr)
+	if condLql != "" || tsLql != "" {
 		lql.WriteString(" WHERE ")
-		q, err := parseGravityQuery(grQuery)
-
-		if err != nil { // Bad query or literal search request
-			lql.WriteString("lower(msg)")
-			lql.WriteString(" CONTAINS ")
-			lql.WriteString("\"" + strings.ToLower(escaper.Replace(grQuery)) + "\"")
-
-		} else { // Good query
-			if len(q.Exp.Or) > 1 {
-				lql.WriteString("(")
-			}
-			var files []string
-			lql.WriteString(buildOrLql(q.Exp.Or, &files))
-			if len(q.Exp.Or) > 1 {
-				lql.WriteString(")")
-			}
-			for _, f := range files { // Unconditionally add files which match condition
-				lql.WriteString(" OR ")
-				lql.WriteString(fmt.Sprintf("fields:file CONTAINS \"%v\"", f))
-			}
-		}
 	}
+	lql.WriteString(condLql)
+	if condLql != "" && tsLql != "" {
+		lql.WriteString(" AND ")
+	}
+	lql.WriteString(tsLql)
 
 	if offset != 0 {
 		lql.WriteString(" OFFSET ")
@@ -134,7 +410,71 @@ func BuildLqlQuery(grQuery string, partition string, limit int, offset int) stri
 	return lql.String()
 }
 
-func buildOrLql(cnd []*orCondition, files *[]string) string {
+// Translates 'Gravity log query' to LQL condition, the top level
+// disjunction is parenthesized, so that it can be combined with other conditions
+func buildGravityLql(grQuery string, opts Options) string {
+	if grQuery == "" {
+		return ""
+	}
+
+	var lql bytes.Buffer
+	q, err := parseGravityQuery(grQuery)
+
+	if err != nil { // Bad query or literal search request
+		return buildTextLql(grQuery, opts)
+	}
+
+	// Good query
+	if len(q.Exp.Or) > 1 {
+		lql.WriteString("(")
+	}
+	lql.WriteString(buildOrLql(q.Exp.Or, opts))
+	if len(q.Exp.Or) > 1 {
+		lql.WriteString(")")
+	}
+	return lql.String()
+}
+
+// Translates time range to LQL condition, the timestamps are
+// given in nanoseconds to keep the precision
+func buildTimeRangeLql(tr TimeRange) string {
+	var conds []string
+	if !tr.Since.IsZero() {
+		conds = append(conds, fmt.Sprintf("ts >= \"%v\"", tr.Since.UnixNano()))
+	}
+	if !tr.Until.IsZero() {
+		conds = append(conds, fmt.Sprintf("ts <= \"%v\"", tr.Until.UnixNano()))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// Translates field condition to LQL condition. The file condition matches
+// either the entry of the container (by the key field, e.g. container id)
+// or the entry of the log file, which name contains the value (or matches the pattern)
+func buildFieldLql(c *condition) string {
+	lql := patternsLql(c.field, c.patterns)
+	if strings.ToLower(c.Key) != fileKey {
+		return lql
+	}
+	fps := make([]pattern, 0, len(c.patterns))
+	for _, p := range c.patterns {
+		if len(p) == 1 && p[0].wc == "" { // exact value, look for the file containing it
+			p = pattern{{wc: "*"}, p[0], {wc: "*"}}
+		}
+		fps = append(fps, p)
+	}
+	return "(" + lql + " OR " + patternsLql(fileField, fps) + ")"
+}
+
+// Translates text term to LQL condition, which looks for the text in the message
+func buildTextLql(text string, opts Options) string {
+	if opts.CaseSensitive {
+		return fmt.Sprintf("msg CONTAINS \"%v\"", escaper.Replace(text))
+	}
+	return fmt.Sprintf("lower(msg) CONTAINS \"%v\"", strings.ToLower(escaper.Replace(text)))
+}
+
+func buildOrLql(cnd []*orCondition, opts Options) string {
 	var orLql bytes.Buffer
 
 	for _, c := range cnd {
@@ -144,7 +484,7 @@ func buildOrLql(cnd []*orCondition, files *[]string) string {
 		if len(c.And) > 1 {
 			orLql.WriteString("(")
 		}
-		orLql.WriteString(buildAndLql(c.And, files))
+		orLql.WriteString(buildAndLql(c.And, opts))
 		if len(c.And) > 1 {
 			orLql.WriteString(")")
 		}
@@ -153,31 +493,33 @@ func buildOrLql(cnd []*orCondition, files *[]string) string {
 	return orLql.String()
 }
 
-func buildAndLql(cnd []*xCondition, files *[]string) string {
+func buildAndLql(cnd []*xCondition, opts Options) string {
 	var andLql bytes.Buffer
 
 	for _, c := range cnd {
 		if andLql.Len() > 1 {
 			andLql.WriteString(" AND ")
 		}
-		if c.Not {
+		not := c.Not
+		if c.Cond != nil && c.Cond.List != nil && c.Cond.List.Not {
+			not = !not // "not pod not in (...)" is the same as "pod in (...)"
+		}
+		if not {
 			andLql.WriteString("NOT ")
 		}
 		if c.Expr != nil {
-			if len(c.Expr.Or) > 1 {
-				andLql.WriteString("(")
-			}
-			andLql.WriteString(buildOrLql(c.Expr.Or, files))
-			if len(c.Expr.Or) > 1 {
-				andLql.WriteString(")")
+			expLql := buildOrLql(c.Expr.Or, opts)
+			// LQL doesn't accept "NOT NOT ...", the negated sub-expression is parenthesized
+			if len(c.Expr.Or) > 1 || not && strings.HasPrefix(expLql, "NOT ") {
+				expLql = "(" + expLql + ")"
 			}
+			andLql.WriteString(expLql)
+		} else if c.Text != nil {
+			andLql.WriteString(buildTextLql(c.Text.Value, opts))
+		} else if op := timeKeyToOp[strings.ToLower(c.Cond.Key)]; op != "" {
+			andLql.WriteString(fmt.Sprintf("ts %v \"%v\"", op, c.Cond.ts.UnixNano()))
 		} else {
-			k, v := strings.ToUpper(c.Cond.Key), escaper.Replace(c.Cond.Value)
-			andLql.WriteString(fmt.Sprintf("fields:%v=\"%v\"", keyToField[k], v))
-			if k == "FILE" {
-				// collect all the files, so that we can add them to the whole query later
-				*files = append(*files, v)
-			}
+			andLql.WriteString(buildFieldLql(c.Cond))
 		}
 	}
 
@@ -17,14 +17,22 @@ limitations under the License.
 package query
 
 import (
-	"github.com/logrange/logrange/pkg/lql"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/logrange/logrange/pkg/model/field"
 )
 
 func Test_BuildLqlQuery(t *testing.T) {
 	type args struct {
 		grQuery string
+		opts    Options
 		pipe    string
+		tr      TimeRange
 	}
 	tests := []struct {
 		name string
@@ -55,9 +63,9 @@ func Test_BuildLqlQuery(t *testing.T) {
 				pipe: "logrange.pipe=__default__",
 			},
 			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:pod=\"po1\" OR " +
-				"(NOT fields:pod=\"pod2\" AND fields:cid=\"file1\" AND fields:cid=\"file2\" " +
-				"AND NOT (fields:cname=\"container1\" OR fields:cname=\"cnt2\"))) " +
-				"OR fields:file CONTAINS \"file1\" OR fields:file CONTAINS \"file2\"",
+				"(NOT fields:pod=\"pod2\" AND (fields:cid=\"file1\" OR fields:file CONTAINS \"file1\") AND " +
+				"(fields:cid=\"file2\" OR fields:file CONTAINS \"file2\") " +
+				"AND NOT (fields:cname=\"container1\" OR fields:cname=\"cnt2\")))",
 		},
 		{
 			name: "build query with file condition ok",
@@ -66,7 +74,7 @@ func Test_BuildLqlQuery(t *testing.T) {
 				pipe:    "logrange.pipe=__default__",
 			},
 			want: "SELECT FROM logrange.pipe=__default__ WHERE " +
-				"(NOT fields:pod=\"pd1\" AND fields:cid=\"fLe1\") OR fields:file CONTAINS \"fLe1\"",
+				"(NOT fields:pod=\"pd1\" AND (fields:cid=\"fLe1\" OR fields:file CONTAINS \"fLe1\"))",
 		},
 		{
 			name: "build query with escaping ok",
@@ -76,11 +84,159 @@ func Test_BuildLqlQuery(t *testing.T) {
 			},
 			want: "SELECT FROM logrange.pipe=__default__ WHERE fields:pod=\"p\\\\d1\"",
 		},
+		{
+			name: "build query with namespace, node and level keys ok",
+			args: args{
+				grQuery: "Namespace:kube-system and node:node-1 and not level:debug",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE " +
+				"(fields:ns=\"kube-system\" AND fields:node=\"node-1\" AND NOT fields:level=\"debug\")",
+		},
+		{
+			name: "build query with field key ok",
+			args: args{
+				grQuery: "field.stream:stderr or pod:pod-1",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:stream=\"stderr\" OR fields:pod=\"pod-1\")",
+		},
+		{
+			name: "build query with unknown key as literal search ok",
+			args: args{
+				grQuery: "image:nginx",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"image:nginx\"",
+		},
+		{
+			name: "build query with glob and regex ok",
+			args: args{
+				grQuery: "pod:nginx-* and not container:~\"sidecar|proxy-.*\" or pod:~core.*",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE ((fields:pod PREFIX \"nginx-\" AND " +
+				"NOT (fields:cname=\"sidecar\" OR fields:cname PREFIX \"proxy-\")) OR fields:pod PREFIX \"core\")",
+		},
+		{
+			name: "build query with quoted glob as exact value ok",
+			args: args{
+				grQuery: "pod:\"nginx-*\"",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE fields:pod=\"nginx-*\"",
+		},
+		{
+			name: "build query with untranslatable regex as literal search ok",
+			args: args{
+				grQuery: "pod:~\"nginx-[a-f]+\"",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"pod:~\\\"nginx-[a-f]+\\\"\"",
+		},
+		{
+			name: "build query with text terms ok",
+			args: args{
+				grQuery: "pod:api and \"Connection refused\" or container:db and not Timeout",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE ((fields:pod=\"api\" AND " +
+				"lower(msg) CONTAINS \"connection refused\") OR (fields:cname=\"db\" AND NOT lower(msg) CONTAINS \"timeout\"))",
+		},
+		{
+			name: "build query with case-sensitive text terms ok",
+			args: args{
+				grQuery: "pod and (\"Connection \\\"refused\\\"\" or 2019-01-01T10:00:00Z)",
+				opts:    Options{CaseSensitive: true},
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (msg CONTAINS \"pod\" AND " +
+				"(msg CONTAINS \"Connection \\\"refused\\\"\" OR msg CONTAINS \"2019-01-01T10:00:00Z\"))",
+		},
+		{
+			name: "build query with case-sensitive literal search ok",
+			args: args{
+				grQuery: "Connection refused",
+				opts:    Options{CaseSensitive: true},
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE msg CONTAINS \"Connection refused\"",
+		},
+		{
+			name: "build query with in and not in ok",
+			args: args{
+				grQuery: "pod IN (p1, \"p 2\", p1, nginx-*) and container not in (c1) or not namespace not in (n1,n2)",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (((fields:pod=\"p1\" OR fields:pod=\"p 2\" OR " +
+				"fields:pod PREFIX \"nginx-\") AND NOT fields:cname=\"c1\") OR (fields:ns=\"n1\" OR fields:ns=\"n2\"))",
+		},
+		{
+			name: "build query with keyword prefixed values ok",
+			args: args{
+				grQuery: "pod:in-cluster-proxy or pod:in.foo or container:not-found and namespace:and_or",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:pod=\"in-cluster-proxy\" OR " +
+				"fields:pod=\"in.foo\" OR (fields:cname=\"not-found\" AND fields:ns=\"and_or\"))",
+		},
+		{
+			name: "build query with keywords before parentheses ok",
+			args: args{
+				grQuery: "pod in(p1, in) and(not(container:c1))",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE ((fields:pod=\"p1\" OR fields:pod=\"in\") AND " +
+				"NOT fields:cname=\"c1\")",
+		},
+		{
+			name: "build query with nested not ok",
+			args: args{
+				grQuery: "not (not pod:p1) and not (container not in (c1))",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (NOT (NOT fields:pod=\"p1\") AND NOT (NOT fields:cname=\"c1\"))",
+		},
+		{
+			name: "build query with in as text ok",
+			args: args{
+				grQuery: "pod in",
+				pipe:    "logrange.pipe=__default__",
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"pod in\"",
+		},
+		{
+			name: "build query with time range only ok",
+			args: args{
+				pipe: "logrange.pipe=__default__",
+				tr:   TimeRange{Since: time.Unix(0, 1), Until: time.Unix(0, 2)},
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE ts >= \"1\" AND ts <= \"2\"",
+		},
+		{
+			name: "build query with time range and literal search ok",
+			args: args{
+				grQuery: "some text",
+				pipe:    "logrange.pipe=__default__",
+				tr:      TimeRange{Until: time.Unix(0, 2)},
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"some text\" AND ts <= \"2\"",
+		},
+		{
+			name: "build query with time range and file condition ok",
+			args: args{
+				grQuery: "pod:p1 or file:f1",
+				pipe:    "logrange.pipe=__default__",
+				tr:      TimeRange{Since: time.Unix(0, 1)},
+			},
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:pod=\"p1\" OR " +
+				"(fields:cid=\"f1\" OR fields:file CONTAINS \"f1\")) AND ts >= \"1\"",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := BuildLqlQuery(tt.args.grQuery, tt.args.pipe, 0, 0)
+			got := BuildLqlQuery(tt.args.grQuery, tt.args.opts, tt.args.pipe, tt.args.tr, 0, 0)
 			if got != tt.want {
 				t.Errorf("BuildLqlQuery() = %v, want %v", got, tt.want)
 			}
@@ -91,3 +247,166 @@ func Test_BuildLqlQuery(t *testing.T) {
 		})
 	}
 }
+
+func Test_FieldToKey(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{field: "pod", want: "pod"},
+		{field: "cname", want: "container"},
+		{field: "ns", want: "namespace"},
+		{field: "image", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			if got := FieldToKey(tt.field); got != tt.want {
+				t.Errorf("FieldToKey() = %v, want %v", got, tt.want)
+			}
+			if tt.want != "" && KeyToField(tt.want) != tt.field {
+				t.Errorf("KeyToField() = %v, want %v", KeyToField(tt.want), tt.field)
+			}
+		})
+	}
+}
+
+func Test_BuildLqlQuery_timePredicates(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 10, 0, 0, 0, time.UTC)
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		grQuery string
+		tr      TimeRange
+		want    string
+	}{
+		{
+			name:    "since duration",
+			grQuery: "since:30m",
+			want:    "SELECT FROM logrange.pipe=__default__ WHERE ts >= \"1546335000000000000\"",
+		},
+		{
+			name:    "after and before timestamps",
+			grQuery: "pod:p1 and after:2019-01-01T09:00:00Z and before:\"2019-01-01T09:30:00.5+00:00\"",
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (fields:pod=\"p1\" AND ts > \"1546333200000000000\" " +
+				"AND ts < \"1546335000500000000\")",
+		},
+		{
+			name:    "combined with or and not",
+			grQuery: "SINCE:1h or not (pod:p1 and before:2h)",
+			want: "SELECT FROM logrange.pipe=__default__ WHERE (ts >= \"1546333200000000000\" OR " +
+				"NOT (fields:pod=\"p1\" AND ts < \"1546329600000000000\"))",
+		},
+		{
+			name:    "combined with time range",
+			grQuery: "since:1h",
+			tr:      TimeRange{Until: now},
+			want:    "SELECT FROM logrange.pipe=__default__ WHERE ts >= \"1546333200000000000\" AND ts <= \"1546336800000000000\"",
+		},
+		{
+			name:    "keyword as value",
+			grQuery: "pod:before",
+			want:    "SELECT FROM logrange.pipe=__default__ WHERE fields:pod=\"before\"",
+		},
+		{
+			name:    "invalid time is literal search",
+			grQuery: "since:yesterday",
+			want:    "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"since:yesterday\"",
+		},
+		{
+			name:    "out of range timestamp is literal search",
+			grQuery: "before:1000-01-01T00:00:00Z",
+			want:    "SELECT FROM logrange.pipe=__default__ WHERE lower(msg) CONTAINS \"before:1000-01-01t00:00:00z\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildLqlQuery(tt.grQuery, Options{}, "logrange.pipe=__default__", tt.tr, 0, 0)
+			if got != tt.want {
+				t.Errorf("BuildLqlQuery() = %v, want %v", got, tt.want)
+			}
+			if _, err := lql.ParseLql(got); err != nil {
+				t.Errorf("BuildLqlQuery() = %v, err= %v", got, err)
+			}
+		})
+	}
+}
+
+// The file conditions are combined with the others in all the boolean contexts,
+// the translated queries are evaluated by Logrange against all the combinations
+// of the event fields and compared with the expected results
+func Test_BuildLqlQuery_fileConditions(t *testing.T) {
+	type event map[string]string
+	type atom struct {
+		q     string
+		match func(e event) bool
+	}
+	atoms := []atom{
+		{q: "pod:a", match: func(e event) bool { return e["pod"] == "a" }},
+		{q: "container:c", match: func(e event) bool { return e["cname"] == "c" }},
+		{q: "file:x", match: func(e event) bool {
+			return e["cid"] == "x" || strings.Contains(e["file"], "x")
+		}},
+		{q: "FILE:x*", match: func(e event) bool {
+			return strings.HasPrefix(e["cid"], "x") || strings.HasPrefix(e["file"], "x")
+		}},
+		{q: "file in (z, y.log)", match: func(e event) bool {
+			return e["cid"] == "z" || e["cid"] == "y.log" || strings.Contains(e["file"], "z") ||
+				strings.Contains(e["file"], "y.log")
+		}},
+		{q: "file not in (x)", match: func(e event) bool {
+			return !(e["cid"] == "x" || strings.Contains(e["file"], "x"))
+		}},
+	}
+	shapes := []struct {
+		q     string
+		match func(a, b, c bool) bool
+	}{
+		{q: "%v and %v or %v", match: func(a, b, c bool) bool { return a && b || c }},
+		{q: "%v or %v and %v", match: func(a, b, c bool) bool { return a || b && c }},
+		{q: "not %v and (%v or %v)", match: func(a, b, c bool) bool { return !a && (b || c) }},
+		{q: "(%v or not %v) and %v", match: func(a, b, c bool) bool { return (a || !b) && c }},
+		{q: "not (%v and %v and not %v)", match: func(a, b, c bool) bool { return !(a && b && !c) }},
+	}
+
+	var events []event
+	for _, pod := range []string{"a", "b"} {
+		for _, cname := range []string{"c", "d"} {
+			for _, cid := range []string{"", "x", "xy", "z"} {
+				for _, file := range []string{"", "x.log", "y.log", "z.log"} {
+					e := event{"pod": pod, "cname": cname}
+					if cid != "" {
+						e["cid"] = cid
+					}
+					if file != "" {
+						e["file"] = file
+					}
+					events = append(events, e)
+				}
+			}
+		}
+	}
+
+	for _, sh := range shapes {
+		for _, a := range atoms {
+			for _, b := range atoms {
+				for _, c := range atoms {
+					q := fmt.Sprintf(sh.q, a.q, b.q, c.q)
+					cond := buildGravityLql(q, Options{})
+					wef, err := lql.BuildWhereExpFunc(cond)
+					if err != nil {
+						t.Fatalf("buildGravityLql(%q) = %v, err = %v", q, cond, err)
+					}
+					for _, e := range events {
+						flds, _ := field.NewFields(e)
+						got := wef(&model.LogEvent{Fields: flds})
+						if want := sh.match(a.match(e), b.match(e), c.match(e)); got != want {
+							t.Fatalf("buildGravityLql(%q) = %v matches %v = %v, want %v", q, cond, e, got, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2019 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/logrange/logrange/api"
+	"github.com/logrange/logrange/pkg/lql"
+	"github.com/logrange/logrange/pkg/model"
+	"github.com/logrange/logrange/pkg/model/field"
+	"github.com/logrange/range/pkg/records"
+)
+
+// The harness evaluates the parsed 'Gravity log query' directly against the events
+// and compares the results with the ones of the translated LQL query evaluated by Logrange.
+// The glob and regular expression wildcards match '/' (see evalGlob), the patterns
+// needing LQL 'LIKE' wildcards (they don't match '/') are rejected, i.e. searched as text.
+func Test_BuildLqlQuery_semantics(t *testing.T) {
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	events := semanticEvents(now)
+	tests := []struct {
+		grQuery string
+		opts    Options
+		tr      TimeRange
+	}{
+		{grQuery: ""},
+		{grQuery: "pod:nginx-1"},
+		{grQuery: "POD:nginx-1 or container:db and not namespace:kube-system"},
+		{grQuery: "not (pod:nginx-1 or pod:coredns) and (container:web or container:db)"},
+		{grQuery: "level:info and not (not pod:nginx-1)"},
+		{grQuery: "field.stream:stderr or node:node-2"},
+		{grQuery: "not (not pod:nginx-1) or not (container not in (web)) and not (not (level:error))"},
+		{grQuery: "level:error and pod:~\"nginx-.*|db-[0-9]\""},
+		{grQuery: "pod:nginx-* or pod:*dns or container:*e* or pod:db-[0-9]"},
+		{grQuery: "pod:db-?"},
+		{grQuery: "pod:~\"(?i)NGINX-\\\\d\" or pod:~.*-1"},
+		{grQuery: "pod in (nginx-1, coredns, db-*) and container not in (web)"},
+		{grQuery: "not pod not in (coredns, \"p \\\"q\\\" r\")"},
+		{grQuery: "pod:\"p \\\"q\\\" r\" or pod:'p,=x'"},
+		{grQuery: "pod:nginx-1 and file:b2"},
+		{grQuery: "pod:coredns or not file:kube and file:*.log"},
+		{grQuery: "file in (a1, apiserver) or file not in (b2)"},
+		{grQuery: "file:~\"/var/log/.*\" and not file:~\".*/b2/.*\" or file:~\".*/x\\\\.log\""},
+		{grQuery: "file:~\"/var/log/.*/x\\\\.log\" or file:~\"/var/log/b./x\\\\.log\""},
+		{grQuery: "file:*b2* and file:*.log"},
+		{grQuery: "file:*x.log or file:*b2*"},
+		{grQuery: "file:*b2*.log or file:*x?log"},
+		{grQuery: "\"connection refused\" or pod:db-1 and timeout"},
+		{grQuery: "Connection and not TIMEOUT", opts: Options{CaseSensitive: true}},
+		{grQuery: "\"say \\\"hi\\\"\" or \"c:\\\\temp\""},
+		{grQuery: "since:30m and before:10m or after:2019-01-01T09:00:00Z and pod:nginx-1"},
+		{grQuery: "pod:db-1 or pod:coredns", tr: TimeRange{Since: now.Add(-time.Hour), Until: now.Add(-time.Minute)}},
+		{grQuery: "Connection Refused"},
+		{grQuery: "say \"hi", opts: Options{CaseSensitive: true}},
+		{grQuery: "c:\\temp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.grQuery, func(t *testing.T) {
+			lqlQuery := BuildLqlQuery(tt.grQuery, tt.opts, "logrange.pipe=__default__", tt.tr, 0, 0)
+			l, err := lql.ParseLql(lqlQuery)
+			if err != nil {
+				t.Fatalf("BuildLqlQuery() = %v, err = %v", lqlQuery, err)
+			}
+			wef := func(*model.LogEvent) bool { return true }
+			if l.Select.Where != nil {
+				if wef, err = lql.BuildWhereExpFuncByExpression(l.Select.Where); err != nil {
+					t.Fatalf("BuildLqlQuery() = %v, err = %v", lqlQuery, err)
+				}
+			}
+
+			q, err := parseGravityQuery(tt.grQuery)
+			matched := 0
+			for _, e := range events {
+				want := evalTimeRange(tt.tr, e)
+				switch {
+				case tt.grQuery == "":
+				case err != nil: // literal text search
+					want = want && evalText(tt.grQuery, tt.opts, e)
+				default:
+					want = want && evalExpression(q.Exp, tt.opts, e)
+				}
+				if want {
+					matched++
+				}
+
+				me := &model.LogEvent{Timestamp: e.Timestamp, Msg: records.Record(e.Message), Fields: field.Parse(e.Fields)}
+				if got := wef(me); got != want {
+					t.Errorf("BuildLqlQuery() = %v matches %+v = %v, want %v", lqlQuery, e, got, want)
+				}
+			}
+			if matched == 0 && tt.grQuery != "" {
+				t.Logf("no events match %q", tt.grQuery)
+			}
+		})
+	}
+}
+
+// Returns the events with all the combinations of the fields and messages,
+// the timestamps are spread over the last 2 hours before the given time
+func semanticEvents(now time.Time) []*api.LogEvent {
+	var res []*api.LogEvent
+	msgs := []string{"Connection refused", "connection REFUSED by peer", "request timeout", "Timeout",
+		"say \"hi\"", "c:\\temp", "ok"}
+	pods := []string{"nginx-1", "NGINX-2", "coredns", "db-1", "db-12", "p \"q\" r", "p,=x"}
+	containers := []string{"web", "db", ""}
+	files := []map[string]string{
+		{"cid": "a1"},
+		{"cid": "b2", "file": "kube-apiserver.log"},
+		{"file": "b2.log"},
+		{"file": "/var/log/b2/x.log"},
+		{},
+	}
+	i := 0
+	for _, msg := range msgs {
+		for _, pod := range pods {
+			for _, cname := range containers {
+				for _, f := range files {
+					flds := map[string]string{"pod": pod, "ns": []string{"default", "kube-system"}[i%2],
+						"node": []string{"node-1", "node-2"}[i%2], "level": []string{"info", "error"}[i%3%2],
+						"stream": []string{"stdout", "stderr"}[i%5%2]}
+					if cname != "" {
+						flds["cname"] = cname
+					}
+					for k, v := range f {
+						flds[k] = v
+					}
+					fs, _ := field.NewFields(flds)
+					ts := now.Add(-time.Duration(i%120) * time.Minute)
+					res = append(res, &api.LogEvent{Timestamp: ts.UnixNano(), Message: msg, Fields: fs.AsKVString()})
+					i++
+				}
+			}
+		}
+	}
+	return res
+}
+
+func evalTimeRange(tr TimeRange, e *api.LogEvent) bool {
+	return (tr.Since.IsZero() || e.Timestamp >= tr.Since.UnixNano()) &&
+		(tr.Until.IsZero() || e.Timestamp <= tr.Until.UnixNano())
+}
+
+func evalText(text string, opts Options, e *api.LogEvent) bool {
+	if opts.CaseSensitive {
+		return strings.Contains(e.Message, text)
+	}
+	return strings.Contains(strings.ToLower(e.Message), strings.ToLower(text))
+}
+
+func evalExpression(exp *expression, opts Options, e *api.LogEvent) bool {
+	for _, or := range exp.Or {
+		res := true
+		for _, x := range or.And {
+			res = res && evalXCondition(x, opts, e)
+		}
+		if res {
+			return true
+		}
+	}
+	return false
+}
+
+func evalXCondition(x *xCondition, opts Options, e *api.LogEvent) bool {
+	var res bool
+	switch {
+	case x.Expr != nil:
+		res = evalExpression(x.Expr, opts, e)
+	case x.Text != nil:
+		res = evalText(x.Text.Value, opts, e)
+	default:
+		res = evalCondition(x.Cond, e)
+	}
+	return res != x.Not
+}
+
+func evalCondition(c *condition, e *api.LogEvent) bool {
+	key := strings.ToLower(c.Key)
+	switch key {
+	case "since":
+		return e.Timestamp >= c.ts.UnixNano()
+	case "after":
+		return e.Timestamp > c.ts.UnixNano()
+	case "before":
+		return e.Timestamp < c.ts.UnixNano()
+	}
+
+	flds := field.Parse(e.Fields)
+	match := func(v string) bool {
+		res := false
+		switch {
+		case c.List != nil:
+			for _, lv := range c.List.Values {
+				if lv.Glob != "" {
+					m := evalGlob(lv.Glob, v)
+					res = res || m
+				} else {
+					res = res || lv.Value == v
+				}
+			}
+		case c.Regex:
+			res = regexp.MustCompile("^(?:" + c.value() + ")$").MatchString(v)
+		case c.Val.Glob != "":
+			res = evalGlob(c.Val.Glob, v)
+		default:
+			res = c.Val.Value == v
+		}
+		return res
+	}
+	res := match(flds.Value(c.field))
+	if key == "file" {
+		file := flds.Value("file")
+		if c.List != nil {
+			for _, lv := range c.List.Values {
+				if lv.Glob != "" {
+					m := evalGlob(lv.Glob, file)
+					res = res || m
+				} else {
+					res = res || strings.Contains(file, lv.Value)
+				}
+			}
+		} else if c.Regex || c.Val.Glob != "" {
+			res = res || match(file)
+		} else {
+			res = res || strings.Contains(file, c.Val.Value)
+		}
+	}
+	if c.List != nil && c.List.Not {
+		return !res
+	}
+	return res
+}
+
+// Matches the value against the glob as documented, '*' and '?' match any characters
+// including '/', the character classes have the same syntax as the regular expression ones
+func evalGlob(glob, v string) bool {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']') + i + 1
+			re.WriteString(glob[i : j+1])
+			i = j
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(v)
+}
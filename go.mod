@@ -12,6 +12,8 @@ require (
 	github.com/logrange/logrange v0.1.46
 	github.com/logrange/range v0.0.0-20191016234805-44ada6216b88
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826
+	github.com/prometheus/client_golang v1.7.1
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
 	gopkg.in/urfave/cli.v2 v2.0.0-20180128182452-d3ae77c26ac8